@@ -0,0 +1,166 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	templatepkg "github.com/AkatukiSora/twitter-dore/internal/template"
+)
+
+// answerSet holds answers supplied non-interactively, keyed by placeholder
+// name (or label for anonymous placeholders).
+type answerSet map[string]answer
+
+// answer is a supplied value together with where it came from, such as
+// "answers.yaml:3" or "--set 年齢", so that errors can point at it.
+type answer struct {
+	value  templatepkg.Value
+	source string
+}
+
+// loadAnswers merges the answers file (if any) with --set assignments; flags
+// take precedence over the file. It returns nil when no source was given.
+func loadAnswers(path string, assignments []string) (answerSet, error) {
+	if path == "" && len(assignments) == 0 {
+		return nil, nil
+	}
+
+	answers := make(answerSet)
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read answers file: %w", err)
+		}
+
+		var nodes map[string]yaml.Node
+		if err := yaml.Unmarshal(data, &nodes); err != nil {
+			return nil, fmt.Errorf("failed to decode answers file %s: %w", path, err)
+		}
+		for key, node := range nodes {
+			source := fmt.Sprintf("%s:%d", path, node.Line)
+			value, err := decodeAnswer(&node)
+			if err != nil {
+				return nil, fmt.Errorf("%s: answer %q: %w", source, key, err)
+			}
+			answers[key] = answer{value: value, source: source}
+		}
+	}
+
+	for _, assignment := range assignments {
+		key, value, ok := strings.Cut(assignment, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid --set value %q (expected key=value)", assignment)
+		}
+		answers[key] = answer{value: templatepkg.TextValue(value), source: "--set " + key}
+	}
+
+	return answers, nil
+}
+
+// decodeAnswer accepts a scalar as a text answer and a sequence of scalars as
+// a list answer.
+func decodeAnswer(node *yaml.Node) (templatepkg.Value, error) {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		return templatepkg.TextValue(node.Value), nil
+	case yaml.SequenceNode:
+		items := make([]string, 0, len(node.Content))
+		for _, item := range node.Content {
+			if item.Kind != yaml.ScalarNode {
+				return templatepkg.Value{}, fmt.Errorf("line %d: list items must be scalars", item.Line)
+			}
+			items = append(items, item.Value)
+		}
+		return templatepkg.ListValue(items...), nil
+	default:
+		return templatepkg.Value{}, fmt.Errorf("line %d: expected a scalar or a list", node.Line)
+	}
+}
+
+// coerce converts a supplied answer to the shape the placeholder expects: a
+// comma-separated text answers a list kind, and a list cannot answer a text
+// kind.
+func coerce(placeholder templatepkg.Placeholder, value templatepkg.Value) (templatepkg.Value, error) {
+	if placeholder.Kind.IsList() {
+		if value.Items == nil {
+			return placeholder.ParseValue(value.Text), nil
+		}
+		return value, nil
+	}
+	if value.Items != nil {
+		return templatepkg.Value{}, errors.New("expected a single value, not a list")
+	}
+	return value, nil
+}
+
+// resolve returns one value per placeholder, falling back to defaults for
+// missing answers. Keys that match no placeholder are reported as errors so
+// that typos do not go unnoticed, while computed placeholders and those hidden
+// by earlier answers are left empty without being checked.
+func (a answerSet) resolve(session *templatepkg.Session, allowEmpty bool) ([]templatepkg.Value, error) {
+	placeholders := session.Placeholders()
+	values := make([]templatepkg.Value, len(placeholders))
+	used := make(map[string]bool, len(a))
+
+	for idx, placeholder := range placeholders {
+		if placeholder.Kind.IsComputed() {
+			continue
+		}
+
+		key := answerKey(placeholder)
+		if !session.Relevant(idx, values) {
+			used[key] = true
+			continue
+		}
+
+		value := placeholder.DefaultValue()
+		source := "default"
+		if supplied, ok := a[key]; ok {
+			used[key] = true
+			coerced, err := coerce(placeholder, supplied.value)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", supplied.source, &templatepkg.ValidationError{Label: placeholder.Label, Err: err})
+			}
+			if !coerced.IsEmpty() {
+				value = coerced
+				source = supplied.source
+			}
+		}
+
+		if (!allowEmpty || placeholder.Required) && value.IsEmpty() {
+			return nil, fmt.Errorf("no answer for %q", placeholder.Label)
+		}
+		if err := placeholder.Validate(value); err != nil {
+			return nil, fmt.Errorf("%s: %w", source, err)
+		}
+		values[idx] = value
+	}
+
+	unknown := make([]string, 0)
+	for key := range a {
+		if !used[key] {
+			unknown = append(unknown, key)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("unknown answer keys: %s", strings.Join(unknown, ", "))
+	}
+
+	return values, nil
+}
+
+// answerKey returns the key used to look up a placeholder's answer. Anonymous
+// placeholders are keyed by their label without the trailing colon, so that
+// "呼び方: {}" is answered with "呼び方".
+func answerKey(placeholder templatepkg.Placeholder) string {
+	if placeholder.Name != "" {
+		return placeholder.Name
+	}
+	return strings.TrimRight(placeholder.Label, ":：")
+}
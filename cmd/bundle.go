@@ -0,0 +1,39 @@
+package cmd
+
+import (
+	"fmt"
+
+	templatepkg "github.com/AkatukiSora/twitter-dore/internal/template"
+)
+
+// loadTemplate loads the template at path. In a bundle the template titled
+// name is used; without a name the user picks one with prompts, which is nil
+// when running non-interactively. It also returns the title the template was
+// chosen by, which is empty for a file holding a single template.
+func loadTemplate(path, name string, prompts prompter) (templatepkg.Document, string, error) {
+	docs, err := templatepkg.LoadBundle(path)
+	if err != nil {
+		return templatepkg.Document{}, "", err
+	}
+
+	if name != "" {
+		doc, err := templatepkg.Find(docs, name)
+		if err != nil {
+			return templatepkg.Document{}, "", fmt.Errorf("%s: %w", path, err)
+		}
+		return doc, name, nil
+	}
+	if len(docs) == 1 {
+		return docs[0], "", nil
+	}
+	if prompts == nil {
+		return templatepkg.Document{}, "", fmt.Errorf("%s holds %d templates; choose one with --name", path, len(docs))
+	}
+
+	titles := templatepkg.Titles(docs)
+	idx, err := prompts.Select("テンプレートを選択", titles, 0)
+	if err != nil {
+		return templatepkg.Document{}, "", err
+	}
+	return docs[idx], titles[idx], nil
+}
@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// editorCommentPrefix starts the header lines of the temporary file opened in
+// the editor. Leading lines with this prefix are removed from the answer; it
+// is specific enough that an answer starting with a hashtag is kept.
+const editorCommentPrefix = "# twitter-dore:"
+
+// editorCommand returns the user's editor from $VISUAL or $EDITOR, split into
+// the program and its arguments, or nil when neither is set.
+func editorCommand() []string {
+	for _, name := range []string{"VISUAL", "EDITOR"} {
+		if fields := strings.Fields(os.Getenv(name)); len(fields) > 0 {
+			return fields
+		}
+	}
+	return nil
+}
+
+// editText opens editor on a temporary file holding the header as comments
+// followed by initial, and returns what the user saved without the leading
+// header lines. The newline editors add at the end of the file is dropped;
+// every other newline is kept.
+func editText(editor []string, header []string, initial string, stdin io.Reader, stdout, stderr io.Writer) (string, error) {
+	file, err := os.CreateTemp("", "twitter-dore-*.txt")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	path := file.Name()
+	defer os.Remove(path)
+
+	var content strings.Builder
+	for _, line := range header {
+		content.WriteString(editorCommentPrefix + " " + line + "\n")
+	}
+	content.WriteString(initial)
+	if _, err := file.WriteString(content.String()); err != nil {
+		file.Close()
+		return "", fmt.Errorf("failed to write temporary file: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return "", fmt.Errorf("failed to write temporary file: %w", err)
+	}
+
+	command := exec.Command(editor[0], append(editor[1:], path)...)
+	command.Stdin = stdin
+	command.Stdout = stdout
+	command.Stderr = stderr
+	if err := command.Run(); err != nil {
+		return "", fmt.Errorf("editor %s failed: %w", editor[0], err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read temporary file: %w", err)
+	}
+	return stripEditorHeader(string(data)), nil
+}
+
+func stripEditorHeader(text string) string {
+	for strings.HasPrefix(text, editorCommentPrefix) {
+		idx := strings.IndexByte(text, '\n')
+		if idx < 0 {
+			return ""
+		}
+		text = text[idx+1:]
+	}
+	text = strings.TrimSuffix(text, "\n")
+	return strings.TrimSuffix(text, "\r")
+}
@@ -0,0 +1,298 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/spf13/cobra"
+
+	templatepkg "github.com/AkatukiSora/twitter-dore/internal/template"
+)
+
+// defaultPostLimit is the length limit of a post, in the weighted characters
+// counted by postLength.
+const defaultPostLimit = 280
+
+const (
+	lintFormatText = "text"
+	lintFormatJSON = "json"
+)
+
+// lintFinding is one problem reported by the lint command. Line and Column
+// are positions in the template body when Template is set, and positions in
+// the file otherwise; both are omitted when the problem has no position.
+type lintFinding struct {
+	File     string               `json:"file"`
+	Template string               `json:"template,omitempty"`
+	Line     int                  `json:"line,omitempty"`
+	Column   int                  `json:"column,omitempty"`
+	Severity templatepkg.Severity `json:"severity"`
+	Message  string               `json:"message"`
+}
+
+func (f lintFinding) String() string {
+	location := f.File
+	if f.Template != "" {
+		location += ": " + f.Template + ":"
+		if f.Line > 0 {
+			location += fmt.Sprintf(" %d:%d:", f.Line, f.Column)
+		}
+	} else if f.Line > 0 {
+		location += fmt.Sprintf(":%d:%d:", f.Line, f.Column)
+	} else {
+		location += ":"
+	}
+	return fmt.Sprintf("%s %s: %s", location, f.Severity, f.Message)
+}
+
+func newLintCmd() *cobra.Command {
+	var (
+		format string
+		limit  int
+		strict bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "lint <files or dirs...>",
+		Short: "Check template YAML files for mistakes",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if format != lintFormatText && format != lintFormatJSON {
+				return fmt.Errorf("invalid --format %q (expected %s or %s)", format, lintFormatText, lintFormatJSON)
+			}
+
+			paths, err := lintTargets(args)
+			if err != nil {
+				return err
+			}
+
+			findings := make([]lintFinding, 0)
+			for _, path := range paths {
+				fileFindings, err := lintFile(path, limit)
+				if err != nil {
+					return err
+				}
+				findings = append(findings, fileFindings...)
+			}
+
+			if err := writeFindings(cmd.OutOrStdout(), format, findings); err != nil {
+				return err
+			}
+
+			failed := 0
+			failedFiles := make(map[string]bool)
+			for _, finding := range findings {
+				if finding.Severity == templatepkg.SeverityError || strict {
+					failed++
+					failedFiles[finding.File] = true
+				}
+			}
+			if failed > 0 {
+				return fmt.Errorf("%s found in %s", pluralize(failed, "problem"), pluralize(len(failedFiles), "file"))
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&format, "format", lintFormatText, "Output format (text|json)")
+	cmd.Flags().IntVar(&limit, "limit", defaultPostLimit, "Post length limit checked against the shortest filling")
+	cmd.Flags().BoolVar(&strict, "strict", false, "Exit with an error on warnings too")
+
+	return cmd
+}
+
+// lintTargets expands directories into the YAML files below them.
+func lintTargets(args []string) ([]string, error) {
+	paths := make([]string, 0, len(args))
+	for _, arg := range args {
+		info, err := os.Stat(arg)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			paths = append(paths, arg)
+			continue
+		}
+		err = filepath.WalkDir(arg, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			ext := filepath.Ext(path)
+			if !entry.IsDir() && (ext == ".yaml" || ext == ".yml") {
+				paths = append(paths, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return paths, nil
+}
+
+// lintFile checks the source of a file, then every template it holds.
+func lintFile(path string, limit int) ([]lintFinding, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	findings := lintSource(path, string(data))
+	sourceProblems, err := templatepkg.CheckKeys(data)
+	if err != nil {
+		return append(findings, lintFinding{File: path, Severity: templatepkg.SeverityError, Message: err.Error()}), nil
+	}
+	versionProblems, err := templatepkg.CheckVersions(data)
+	if err != nil {
+		return append(findings, lintFinding{File: path, Severity: templatepkg.SeverityError, Message: err.Error()}), nil
+	}
+	for _, problem := range append(sourceProblems, versionProblems...) {
+		findings = append(findings, lintFinding{
+			File:     path,
+			Line:     problem.Pos.Line,
+			Column:   problem.Pos.Column,
+			Severity: problem.Severity,
+			Message:  problem.Message,
+		})
+	}
+
+	docs, err := templatepkg.LoadBundle(path)
+	if err != nil {
+		return append(findings, lintFinding{File: path, Severity: templatepkg.SeverityError, Message: err.Error()}), nil
+	}
+	titles := templatepkg.Titles(docs)
+	for i, doc := range docs {
+		title := titles[i]
+		if len(docs) == 1 && doc.Title == "" {
+			title = "template"
+		}
+		findings = append(findings, lintTemplate(path, title, doc, limit)...)
+	}
+	return findings, nil
+}
+
+// lintSource reports whitespace that is easy to miss in an editor: trailing
+// spaces, tabs and CRLF line endings, which is reported once per file.
+func lintSource(path, source string) []lintFinding {
+	findings := make([]lintFinding, 0)
+	// warn reports a problem on line just after the text before it.
+	warn := func(line int, before, message string) {
+		findings = append(findings, lintFinding{
+			File:     path,
+			Line:     line,
+			Column:   utf8.RuneCountInString(before) + 1,
+			Severity: templatepkg.SeverityWarning,
+			Message:  message,
+		})
+	}
+
+	crlf := false
+	for i, line := range strings.Split(source, "\n") {
+		if trimmed, ok := strings.CutSuffix(line, "\r"); ok {
+			if !crlf {
+				crlf = true
+				warn(i+1, trimmed, "CRLF line endings")
+			}
+			line = trimmed
+		}
+
+		content := strings.TrimRight(line, " \t　")
+		if content != line {
+			warn(i+1, content, "trailing whitespace")
+		}
+		if idx := strings.IndexByte(content, '\t'); idx >= 0 {
+			warn(i+1, content[:idx], "tab character")
+		}
+	}
+	return findings
+}
+
+// lintTemplate validates one template and checks that it fits in a post when
+// every placeholder is given its shortest answer.
+func lintTemplate(path, title string, doc templatepkg.Document, limit int) []lintFinding {
+	finding := func(pos templatepkg.Pos, severity templatepkg.Severity, message string) lintFinding {
+		return lintFinding{File: path, Template: title, Line: pos.Line, Column: pos.Column, Severity: severity, Message: message}
+	}
+
+	if strings.TrimSpace(doc.Template) == "" {
+		return []lintFinding{finding(templatepkg.Pos{}, templatepkg.SeverityError, templatepkg.ErrTemplateMissing.Error())}
+	}
+
+	problems, err := doc.Check()
+	if err != nil {
+		var parseErr *templatepkg.ParseError
+		if errors.As(err, &parseErr) {
+			return []lintFinding{finding(parseErr.Pos, templatepkg.SeverityError, parseErr.Err.Error())}
+		}
+		return []lintFinding{finding(templatepkg.Pos{}, templatepkg.SeverityError, err.Error())}
+	}
+
+	findings := make([]lintFinding, 0, len(problems))
+	for _, problem := range problems {
+		findings = append(findings, finding(problem.Pos, problem.Severity, problem.Message))
+	}
+
+	session, err := doc.NewSession()
+	if err != nil {
+		return findings
+	}
+	// The shortest answers can still make an expression fail, such as a
+	// division by an int answered 0, which is no mistake in the template.
+	shortest, err := session.Fill(session.MinimalValues())
+	if err != nil {
+		return append(findings, finding(templatepkg.Pos{}, templatepkg.SeverityWarning, "length unknown: "+err.Error()))
+	}
+	if length := postLength(shortest); length > limit {
+		findings = append(findings, finding(templatepkg.Pos{}, templatepkg.SeverityError,
+			fmt.Sprintf("the shortest filling is %d characters, over the limit of %d", length, limit)))
+	}
+	return findings
+}
+
+// pluralize formats a count of noun, adding "s" unless there is exactly one.
+func pluralize(n int, noun string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, noun)
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
+
+func writeFindings(w io.Writer, format string, findings []lintFinding) error {
+	if format == lintFormatJSON {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(findings)
+	}
+	for _, finding := range findings {
+		if _, err := fmt.Fprintln(w, finding); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// postLength counts text the way Twitter does: most Latin, punctuation and
+// symbol characters count as 1 and everything else, including CJK and emoji,
+// as 2. URLs are counted as written rather than as shortened links.
+func postLength(text string) int {
+	length := 0
+	for _, r := range text {
+		switch {
+		case r <= 0x10FF,
+			r >= 0x2000 && r <= 0x200D,
+			r >= 0x2010 && r <= 0x201F,
+			r >= 0x2032 && r <= 0x2037:
+			length++
+		default:
+			length += 2
+		}
+	}
+	return length
+}
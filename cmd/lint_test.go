@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	templatepkg "github.com/AkatukiSora/twitter-dore/internal/template"
+)
+
+func TestLint(t *testing.T) {
+	withTerminal(t, false)
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"clean.yaml": "version: 2\ntitle: 好きなところ\ntemplate: \"呼び方: {}\"\n",
+		"typo.yaml":  "version: 2\ntitel: x\ntemplate: \"{} \"\nfields:\n  名前:\n    lable: x\n",
+		"crlf.yaml":  "version: 2\r\ndescription: \"a\tb\"\r\ntemplate: \"a: {}\"   \r\n",
+		"long.yaml":  "version: 2\ntemplate: \"" + strings.Repeat("あ", 141) + "{a}\"\n",
+		"ratio.yaml": "version: 2\ntemplate: \"{x:int} / {= 100 / x}\"\n",
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+
+	cmd := NewRootCmd()
+	outBuf := &bytes.Buffer{}
+	cmd.SetOut(outBuf)
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetArgs([]string{"lint", filepath.Join(dir, "clean.yaml")})
+
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("expected a clean file to pass, got %v\n%s", err, outBuf.String())
+	}
+	if outBuf.String() != "" {
+		t.Fatalf("expected no findings, got:\n%s", outBuf.String())
+	}
+
+	cmd = NewRootCmd()
+	outBuf = &bytes.Buffer{}
+	cmd.SetOut(outBuf)
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetArgs([]string{"lint", filepath.Join(dir, "ratio.yaml")})
+
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("expected a failing shortest filling to be a warning, got %v\n%s", err, outBuf.String())
+	}
+
+	cmd = NewRootCmd()
+	outBuf = &bytes.Buffer{}
+	cmd.SetOut(outBuf)
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetArgs([]string{"lint", dir})
+
+	err := cmd.Execute()
+	if err == nil || !strings.Contains(err.Error(), "4 problems found in 2 files") {
+		t.Fatalf("expected lint to fail, got %v\n%s", err, outBuf.String())
+	}
+	for _, want := range []string{
+		"crlf.yaml:1:11: warning: CRLF line endings",
+		"crlf.yaml:2:16: warning: tab character",
+		"crlf.yaml:3:18: warning: trailing whitespace",
+		"ratio.yaml: template: warning: length unknown: ",
+		"long.yaml: template: error: the shortest filling is 282 characters, over the limit of 280",
+		`typo.yaml:2:1: error: unknown key "titel"`,
+		`typo.yaml:6:1: error: unknown key "lable"`,
+		`typo.yaml: template: 1:1: warning: placeholder has no label and is asked as "field1"`,
+		`typo.yaml: template: error: field "名前" is declared but not used in the template`,
+	} {
+		if !strings.Contains(outBuf.String(), want) {
+			t.Fatalf("expected output to contain %q, got:\n%s", want, outBuf.String())
+		}
+	}
+
+	cmd = NewRootCmd()
+	outBuf = &bytes.Buffer{}
+	cmd.SetOut(outBuf)
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetArgs([]string{"lint", "--format", "json", "--strict", filepath.Join(dir, "crlf.yaml")})
+
+	if err := cmd.Execute(); err == nil || err.Error() != "3 problems found in 1 file" {
+		t.Fatalf("expected --strict to fail on warnings, got %v", err)
+	}
+	var findings []lintFinding
+	if err := json.Unmarshal(outBuf.Bytes(), &findings); err != nil {
+		t.Fatalf("decode JSON output: %v\n%s", err, outBuf.String())
+	}
+	if len(findings) == 0 || findings[0].Severity != templatepkg.SeverityWarning || findings[0].Line != 1 {
+		t.Fatalf("unexpected findings: %+v", findings)
+	}
+}
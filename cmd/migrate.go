@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	templatepkg "github.com/AkatukiSora/twitter-dore/internal/template"
+)
+
+func newMigrateCmd() *cobra.Command {
+	var check bool
+
+	cmd := &cobra.Command{
+		Use:   "migrate <files...>",
+		Short: "Rewrite template YAML files to the current schema version",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			outdated := 0
+			for _, path := range args {
+				data, err := os.ReadFile(path)
+				if err != nil {
+					return err
+				}
+
+				migrated, from, err := templatepkg.Migrate(data)
+				if err != nil {
+					return fmt.Errorf("%s: %w", path, err)
+				}
+				if bytes.Equal(migrated, data) {
+					continue
+				}
+				outdated++
+
+				if check {
+					if _, err := fmt.Fprintf(
+						cmd.OutOrStdout(), "%s: version %d needs migration to %d\n", path, from, templatepkg.CurrentVersion,
+					); err != nil {
+						return err
+					}
+					continue
+				}
+
+				if err := os.WriteFile(path, migrated, 0o644); err != nil {
+					return fmt.Errorf("failed to write %s: %w", path, err)
+				}
+				if _, err := fmt.Fprintf(
+					cmd.OutOrStdout(), "%s: migrated from version %d to %d\n", path, from, templatepkg.CurrentVersion,
+				); err != nil {
+					return err
+				}
+			}
+
+			if check && outdated > 0 {
+				return fmt.Errorf("%d of %d files need migration", outdated, len(args))
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&check, "check", false, "Only report files that need migration; exit with an error if any do")
+
+	return cmd
+}
@@ -0,0 +1,193 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	templatepkg "github.com/AkatukiSora/twitter-dore/internal/template"
+)
+
+const legacyTemplate = `# 顔文字テンプレ
+title: 顔文字
+template: |-
+  (^_^){ {} } C:\{}
+`
+
+func TestMigrate(t *testing.T) {
+	withTerminal(t, false)
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tpl.yaml")
+	if err := os.WriteFile(path, []byte(legacyTemplate), 0o644); err != nil {
+		t.Fatalf("write template: %v", err)
+	}
+
+	doc, err := templatepkg.LoadFile(path)
+	if err != nil {
+		t.Fatalf("expected the legacy template to load, got %v", err)
+	}
+	if want := `(^_^)\{ {} \} C:\\{}`; doc.Template != want || doc.Version != templatepkg.CurrentVersion {
+		t.Fatalf("unexpected upgrade: want %q, got %q (version %d)", want, doc.Template, doc.Version)
+	}
+
+	cmd := NewRootCmd()
+	outBuf := &bytes.Buffer{}
+	cmd.SetOut(outBuf)
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetArgs([]string{"migrate", "--check", path})
+
+	if err := cmd.Execute(); err == nil || !strings.Contains(err.Error(), "1 of 1 files need migration") {
+		t.Fatalf("expected --check to fail, got %v", err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != legacyTemplate {
+		t.Fatalf("expected --check to leave the file alone, got:\n%s", data)
+	}
+
+	cmd = NewRootCmd()
+	cmd.SetOut(&bytes.Buffer{})
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetArgs([]string{"migrate", path})
+
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("execute: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read migrated file: %v", err)
+	}
+	for _, want := range []string{"# 顔文字テンプレ\nversion: 2\n", "template: |-\n  (^_^)\\{ {} \\} C:\\\\{}"} {
+		if !strings.Contains(string(data), want) {
+			t.Fatalf("expected migrated file to contain %q, got:\n%s", want, data)
+		}
+	}
+
+	cmd = NewRootCmd()
+	cmd.SetOut(&bytes.Buffer{})
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetArgs([]string{"migrate", "--check", path})
+
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("expected migrated file to pass --check, got %v", err)
+	}
+
+	source := "title: a\ntemplate: |-\n    { x\nfields:\n    名前:\n        choices:\n            - a\n            - b\n"
+	migrated, _, err := templatepkg.Migrate([]byte(source))
+	if err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	want := "version: 2\ntitle: a\ntemplate: |-\n    \\{ x\nfields:\n    名前:\n        choices:\n            - a\n            - b\n"
+	if string(migrated) != want {
+		t.Fatalf("expected the indentation to be kept: want %q, got %q", want, migrated)
+	}
+}
+
+func TestMigrateBundles(t *testing.T) {
+	source := "title: a\ntemplate: \"{} :}\"\n---\ntemplates:\n  - template: \"{ y\"\n  - version: 2\n    template: \"\\\\{z}\"\n"
+
+	data, from, err := templatepkg.Migrate([]byte(source))
+	if err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	if from != 1 {
+		t.Fatalf("expected version 1, got %d", from)
+	}
+
+	path := filepath.Join(t.TempDir(), "pack.yaml")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatalf("write bundle: %v", err)
+	}
+	docs, err := templatepkg.LoadBundle(path)
+	if err != nil {
+		t.Fatalf("load migrated bundle: %v\n%s", err, data)
+	}
+	want := []string{"{} :\\}", "\\{ y", "\\{z}"}
+	for i, doc := range docs {
+		if doc.Template != want[i] {
+			t.Fatalf("template %d: want %q, got %q in:\n%s", i, want[i], doc.Template, data)
+		}
+	}
+}
+
+func TestRunUnversionedTemplates(t *testing.T) {
+	withTerminal(t, false)
+	withRunPrompter(t, []string{"alice", "歌"})
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"base.yaml":   "template: |-\n  {名前}さんの好きなところ\n  {$好き}\n  ・{}\n  {/}\n  {>footer}\n",
+		"fandom.yaml": "title: 推しの好きなところ\nextends: base\nblocks:\n  好き: \"推しポイント: {推し}\"\n",
+		"footer.yaml": "template: \"またね\"\n",
+		"strict.yaml": "template: \"{名前}さん :}\"\n",
+		"legacy.yaml": "template: \"{x|upper} {}\"\n",
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+
+	cmd := NewRootCmd()
+	outBuf := &bytes.Buffer{}
+	cmd.SetOut(outBuf)
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetArgs([]string{"run", "--in", filepath.Join(dir, "fandom.yaml")})
+
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("execute: %v", err)
+	}
+	if expected := "aliceさんの好きなところ\n推しポイント: 歌\nまたね"; outBuf.String() != expected {
+		t.Fatalf("unexpected output: want %q, got %q", expected, outBuf.String())
+	}
+
+	cmd = NewRootCmd()
+	cmd.SetOut(&bytes.Buffer{})
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetArgs([]string{"run", "--in", filepath.Join(dir, "strict.yaml"), "--set", "名前=a"})
+	if err := cmd.Execute(); err == nil || !strings.Contains(err.Error(), "1:9: unmatched }") {
+		t.Fatalf("expected an unversioned template in later syntax to be parsed strictly, got %v", err)
+	}
+
+	cmd = NewRootCmd()
+	outBuf = &bytes.Buffer{}
+	cmd.SetOut(outBuf)
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetArgs([]string{"lint", filepath.Join(dir, "legacy.yaml"), filepath.Join(dir, "fandom.yaml")})
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("expected warnings only, got %v\n%s", err, outBuf.String())
+	}
+	for _, want := range []string{
+		"legacy.yaml:1:1: warning: no version, so braces and backslashes that form no placeholder are printed as text",
+		"fandom.yaml:1:1: warning: no version; add version: 2",
+	} {
+		if !strings.Contains(outBuf.String(), want) {
+			t.Fatalf("expected output to contain %q, got:\n%s", want, outBuf.String())
+		}
+	}
+}
+
+func TestMigrateRejectsNewerVersions(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tpl.yaml")
+	if err := os.WriteFile(path, []byte("version: 99\ntemplate: x\n"), 0o644); err != nil {
+		t.Fatalf("write template: %v", err)
+	}
+
+	_, err := templatepkg.LoadFile(path)
+	if !errors.Is(err, templatepkg.ErrVersionTooNew) || !strings.Contains(err.Error(), "version 99") {
+		t.Fatalf("expected a version error, got %v", err)
+	}
+
+	cmd := NewRootCmd()
+	cmd.SetOut(&bytes.Buffer{})
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetArgs([]string{"migrate", path})
+
+	if err := cmd.Execute(); !errors.Is(err, templatepkg.ErrVersionTooNew) {
+		t.Fatalf("expected migrate to refuse newer versions, got %v", err)
+	}
+}
@@ -21,6 +21,7 @@ func newNewCmd() *cobra.Command {
 	var (
 		outPath         string
 		force           bool
+		appendFlag      bool
 		titleFlag       string
 		descriptionFlag string
 		inlineTemplate  string
@@ -35,7 +36,10 @@ func newNewCmd() *cobra.Command {
 				return errors.New("--out is required")
 			}
 
-			if err := ensureWritable(outPath, force); err != nil {
+			if force && appendFlag {
+				return errors.New("only one of --force or --append may be set")
+			}
+			if err := ensureWritable(outPath, force || appendFlag); err != nil {
 				return err
 			}
 
@@ -46,18 +50,19 @@ func newNewCmd() *cobra.Command {
 			case inlineTemplate != "" && templateFile != "":
 				return errors.New("only one of --template-inline or --template-file may be set")
 			case inlineTemplate != "":
-				return writeTemplateFile(cmd, outPath, titleFlag, descriptionFlag, decodeInline(inlineTemplate), styler, force)
+				return writeTemplateFile(cmd, outPath, titleFlag, descriptionFlag, decodeInline(inlineTemplate), styler, force, appendFlag)
 			case templateFile != "":
 				body, err := os.ReadFile(templateFile)
 				if err != nil {
 					return fmt.Errorf("failed to read template file: %w", err)
 				}
 
-				return writeTemplateFile(cmd, outPath, titleFlag, descriptionFlag, string(body), styler, force)
+				return writeTemplateFile(cmd, outPath, titleFlag, descriptionFlag, string(body), styler, force, appendFlag)
 			default:
 				return runInteractiveNew(cmd, interactiveInputs{
 					outPath:     outPath,
 					force:       force,
+					append:      appendFlag,
 					title:       titleFlag,
 					description: descriptionFlag,
 				})
@@ -67,6 +72,7 @@ func newNewCmd() *cobra.Command {
 
 	cmd.Flags().StringVar(&outPath, "out", "", "Path for the generated YAML template")
 	cmd.Flags().BoolVar(&force, "force", false, "Overwrite the output file if it exists")
+	cmd.Flags().BoolVar(&appendFlag, "append", false, "Add the template to the output file as a bundle if it exists")
 	cmd.Flags().StringVar(&titleFlag, "title", "", "Template title")
 	cmd.Flags().StringVar(&descriptionFlag, "description", "", "Template description")
 	cmd.Flags().StringVar(&inlineTemplate, "template-inline", "", "Template body provided inline (supports \\n escape sequences)")
@@ -80,6 +86,7 @@ func newNewCmd() *cobra.Command {
 type interactiveInputs struct {
 	outPath     string
 	force       bool
+	append      bool
 	title       string
 	description string
 }
@@ -92,7 +99,7 @@ func runInteractiveNew(cmd *cobra.Command, inputs interactiveInputs) error {
 
 	title := inputs.title
 	if title == "" {
-		title, err = prompter.Ask("title", true)
+		title, err = prompter.Ask(question{label: "title", allowEmpty: true})
 		if err != nil {
 			return err
 		}
@@ -100,7 +107,7 @@ func runInteractiveNew(cmd *cobra.Command, inputs interactiveInputs) error {
 
 	description := inputs.description
 	if description == "" {
-		description, err = prompter.Ask("description", true)
+		description, err = prompter.Ask(question{label: "description", allowEmpty: true})
 		if err != nil {
 			return err
 		}
@@ -109,7 +116,7 @@ func runInteractiveNew(cmd *cobra.Command, inputs interactiveInputs) error {
 	lines := make([]string, 0)
 	for {
 		label := fmt.Sprintf("template line %d (enter %s to finish)", len(lines)+1, templateEndToken)
-		line, err := prompter.Ask(label, true)
+		line, err := prompter.Ask(question{label: label, allowEmpty: true})
 		if err != nil {
 			return err
 		}
@@ -141,19 +148,21 @@ func runInteractiveNew(cmd *cobra.Command, inputs interactiveInputs) error {
 		return err
 	}
 
-	if err := writeTemplateFile(cmd, inputs.outPath, title, description, body, styler, inputs.force); err != nil {
+	if err := writeTemplateFile(cmd, inputs.outPath, title, description, body, styler, inputs.force, inputs.append); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func writeTemplateFile(cmd *cobra.Command, outPath, title, description, body string, styler ui.Styler, force bool) error {
+// writeTemplateFile validates and saves the template. With appendToBundle
+// it is added to an existing file, turning it into a bundle.
+func writeTemplateFile(cmd *cobra.Command, outPath, title, description, body string, styler ui.Styler, force, appendToBundle bool) error {
 	if strings.TrimSpace(body) == "" {
 		return errors.New("template body is empty")
 	}
 
-	if err := ensureWritable(outPath, force); err != nil {
+	if err := ensureWritable(outPath, force || appendToBundle); err != nil {
 		return err
 	}
 
@@ -162,8 +171,15 @@ func writeTemplateFile(cmd *cobra.Command, outPath, title, description, body str
 		Description: description,
 		Template:    body,
 	}
+	if err := doc.Validate(); err != nil {
+		return err
+	}
 
-	if err := templatepkg.WriteFile(outPath, doc); err != nil {
+	write := templatepkg.WriteFile
+	if appendToBundle {
+		write = templatepkg.AppendFile
+	}
+	if err := write(outPath, doc); err != nil {
 		return err
 	}
 
@@ -184,7 +200,7 @@ func writeTemplateFile(cmd *cobra.Command, outPath, title, description, body str
 func ensureWritable(path string, force bool) error {
 	if !force {
 		if _, err := os.Stat(path); err == nil {
-			return fmt.Errorf("%s already exists (use --force to overwrite)", path)
+			return fmt.Errorf("%s already exists (use --force to overwrite or --append to add to it)", path)
 		} else if !errors.Is(err, fs.ErrNotExist) {
 			return err
 		}
@@ -192,6 +208,9 @@ func ensureWritable(path string, force bool) error {
 	return nil
 }
 
+// decodeInline turns the escape sequences \n, \t and \r of --template-inline
+// into the characters they stand for. Other backslash sequences, including the
+// template escapes \{, \} and \\, are kept for the template parser.
 func decodeInline(value string) string {
 	var builder strings.Builder
 
@@ -213,9 +232,6 @@ func decodeInline(value string) string {
 		case 'r':
 			builder.WriteByte('\r')
 			i++
-		case '\\':
-			builder.WriteByte('\\')
-			i++
 		default:
 			builder.WriteByte(ch)
 			builder.WriteByte(next)
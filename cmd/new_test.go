@@ -2,6 +2,9 @@ package cmd
 
 import (
 	"bytes"
+	"errors"
+	"io/fs"
+	"os"
 	"path/filepath"
 	"strings"
 	"testing"
@@ -46,6 +49,80 @@ func TestNewNonInteractive(t *testing.T) {
 	}
 }
 
+func TestNewRejectsSyntaxErrors(t *testing.T) {
+	withTerminal(t, false)
+
+	dir := t.TempDir()
+	outPath := filepath.Join(dir, "tpl.yaml")
+
+	cmd := NewRootCmd()
+	cmd.SetOut(&bytes.Buffer{})
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetArgs([]string{"new", "--out", outPath, "--template-inline", `A:{}\nB:{`})
+
+	err := cmd.Execute()
+	if err == nil || !strings.Contains(err.Error(), "2:3:") {
+		t.Fatalf("expected a positioned syntax error, got %v", err)
+	}
+	if _, statErr := os.Stat(outPath); !errors.Is(statErr, fs.ErrNotExist) {
+		t.Fatalf("expected no file to be written, got %v", statErr)
+	}
+}
+
+func TestNewAppend(t *testing.T) {
+	withTerminal(t, false)
+
+	dir := t.TempDir()
+	outPath := filepath.Join(dir, "pack.yaml")
+
+	for _, title := range []string{"あいさつ", "好きなところ"} {
+		cmd := NewRootCmd()
+		cmd.SetOut(&bytes.Buffer{})
+		cmd.SetErr(&bytes.Buffer{})
+		cmd.SetArgs([]string{"new", "--out", outPath, "--append", "--title", title, "--template-inline", title + ": {}"})
+
+		if err := cmd.Execute(); err != nil {
+			t.Fatalf("execute %s: %v", title, err)
+		}
+	}
+
+	docs, err := templatepkg.LoadBundle(outPath)
+	if err != nil {
+		t.Fatalf("load bundle: %v", err)
+	}
+	if got := templatepkg.Titles(docs); strings.Join(got, ",") != "あいさつ,好きなところ" {
+		t.Fatalf("unexpected titles: %v", got)
+	}
+
+	cmd := NewRootCmd()
+	cmd.SetOut(&bytes.Buffer{})
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetArgs([]string{"new", "--out", outPath, "--append", "--title", "あいさつ", "--template-inline", "x"})
+	if err := cmd.Execute(); err == nil || !strings.Contains(err.Error(), `already has a template titled "あいさつ"`) {
+		t.Fatalf("expected duplicate titles to be rejected, got %v", err)
+	}
+
+	listPath := filepath.Join(dir, "list.yaml")
+	list := "# まとめ\ntemplates:\n  - title: あいさつ\n    template: おはよう\n"
+	if err := os.WriteFile(listPath, []byte(list), 0o644); err != nil {
+		t.Fatalf("write bundle: %v", err)
+	}
+	if err := templatepkg.AppendFile(listPath, templatepkg.Document{Title: "質問箱", Template: "Q. {}"}); err != nil {
+		t.Fatalf("append: %v", err)
+	}
+	data, err := os.ReadFile(listPath)
+	if err != nil {
+		t.Fatalf("read bundle: %v", err)
+	}
+	if !strings.HasPrefix(string(data), "# まとめ\n") || strings.Contains(string(data), "---") {
+		t.Fatalf("expected the entry to join the templates list, got:\n%s", data)
+	}
+	docs, err = templatepkg.LoadBundle(listPath)
+	if err != nil || len(docs) != 2 || docs[1].Title != "質問箱" {
+		t.Fatalf("unexpected bundle %+v: %v", docs, err)
+	}
+}
+
 func TestNewInteractiveSmoke(t *testing.T) {
 	withTerminal(t, true)
 	responses := []string{
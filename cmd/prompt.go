@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"errors"
+	"fmt"
 	"io"
 	"strings"
 
@@ -9,10 +10,34 @@ import (
 	"github.com/spf13/cobra"
 )
 
+// question describes a single free-text prompt.
+type question struct {
+	label string
+	// defaultValue pre-fills the input and is accepted on a bare Enter.
+	defaultValue string
+	allowEmpty   bool
+	// validate, when set, rejects non-empty answers so the user is asked again.
+	validate func(string) error
+}
+
 type prompter interface {
-	Ask(label string, allowEmpty bool) (string, error)
+	Ask(q question) (string, error)
+	// Select lets the user pick one of items and returns its index.
+	Select(label string, items []string, initial int) (int, error)
+	// MultiSelect lets the user toggle any number of items, starting from
+	// selected, and returns the indexes of the chosen items in order.
+	MultiSelect(label string, items []string, selected []bool) ([]int, error)
+	// AskMultiline asks for text that may span several lines, showing header
+	// as context. The answer is not validated.
+	AskMultiline(q question, header []string) (string, error)
 }
 
+const (
+	multiSelectChecked   = "☑"
+	multiSelectUnchecked = "☐"
+	multiSelectDone      = "決定"
+)
+
 type promptFactory func(*cobra.Command) (prompter, error)
 
 var defaultPromptFactory promptFactory = newPromptUIPrompter
@@ -32,19 +57,23 @@ func newPromptUIPrompter(cmd *cobra.Command) (prompter, error) {
 	}, nil
 }
 
-func (p *promptUIPrompter) Ask(label string, allowEmpty bool) (string, error) {
+func (p *promptUIPrompter) Ask(q question) (string, error) {
 	validate := func(input string) error {
-		if allowEmpty {
-			return nil
-		}
 		if strings.TrimSpace(input) == "" {
+			if q.allowEmpty {
+				return nil
+			}
 			return errors.New("入力が必要です")
 		}
+		if q.validate != nil {
+			return q.validate(input)
+		}
 		return nil
 	}
 
 	prompt := promptui.Prompt{
-		Label:     label,
+		Label:     q.label,
+		Default:   q.defaultValue,
 		AllowEdit: true,
 		Validate:  validate,
 		Stdin:     p.reader,
@@ -59,6 +88,91 @@ func (p *promptUIPrompter) Ask(label string, allowEmpty bool) (string, error) {
 	return result, nil
 }
 
+func (p *promptUIPrompter) Select(label string, items []string, initial int) (int, error) {
+	prompt := promptui.Select{
+		Label:     label,
+		Items:     items,
+		CursorPos: initial,
+		Stdin:     p.reader,
+		Stdout:    p.writer,
+	}
+
+	idx, _, err := prompt.Run()
+	if err != nil {
+		return 0, err
+	}
+
+	return idx, nil
+}
+
+// MultiSelect emulates a checkbox list with promptui.Select: choosing an item
+// toggles it and the menu is shown again until the final entry is chosen.
+func (p *promptUIPrompter) MultiSelect(label string, items []string, selected []bool) ([]int, error) {
+	state := append([]bool(nil), selected...)
+	cursor := 0
+
+	for {
+		entries := make([]string, 0, len(items)+1)
+		for i, item := range items {
+			marker := multiSelectUnchecked
+			if state[i] {
+				marker = multiSelectChecked
+			}
+			entries = append(entries, marker+" "+item)
+		}
+		entries = append(entries, multiSelectDone)
+
+		idx, err := p.Select(label, entries, cursor)
+		if err != nil {
+			return nil, err
+		}
+		if idx == len(items) {
+			break
+		}
+
+		state[idx] = !state[idx]
+		cursor = idx
+	}
+
+	indexes := make([]int, 0, len(items))
+	for i, checked := range state {
+		if checked {
+			indexes = append(indexes, i)
+		}
+	}
+	return indexes, nil
+}
+
+// AskMultiline opens $VISUAL or $EDITOR on a temporary file pre-filled with
+// the header and the default value. Without an editor it asks line by line
+// until the end token used by the new command, relying on the caller to have
+// shown the context; ending before any line keeps the default.
+func (p *promptUIPrompter) AskMultiline(q question, header []string) (string, error) {
+	if editor := editorCommand(); editor != nil {
+		// The editor draws on the prompt's terminal, not on stdout, which
+		// carries the result and is often piped.
+		return editText(editor, header, q.defaultValue, p.reader, p.writer, p.writer)
+	}
+
+	lines := make([]string, 0)
+	for {
+		label := fmt.Sprintf("%s %d 行目 (%s で終了)", q.label, len(lines)+1, templateEndToken)
+		line, err := p.Ask(question{label: label, allowEmpty: true})
+		if err != nil {
+			return "", err
+		}
+		if line == templateEndToken {
+			break
+		}
+		lines = append(lines, line)
+	}
+
+	if len(lines) == 0 {
+		return q.defaultValue, nil
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
 type nopWriteCloser struct {
 	io.Writer
 }
@@ -2,12 +2,14 @@ package cmd
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 
 	"github.com/spf13/cobra"
 
+	templatepkg "github.com/AkatukiSora/twitter-dore/internal/template"
 	"github.com/AkatukiSora/twitter-dore/internal/ui"
 )
 
@@ -27,6 +29,10 @@ var (
 func Execute() {
 	if err := rootCmd.Execute(); err != nil {
 		rootCmd.PrintErrln(fmt.Sprintf("Error: %v", err))
+		var parseErr *templatepkg.ParseError
+		if errors.As(err, &parseErr) {
+			rootCmd.PrintErrln(parseErr.Excerpt())
+		}
 		os.Exit(1)
 	}
 }
@@ -54,6 +60,10 @@ through filling placeholders interactively.`,
 	cmd.AddCommand(
 		newRunCmd(),
 		newNewCmd(),
+		newShowCmd(),
+		newMigrateCmd(),
+		newLintCmd(),
+		newSchemaCmd(),
 		newVersionCmd(),
 		newCompletionCmd(),
 	)
@@ -3,22 +3,35 @@ package cmd
 import (
 	"errors"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 
+	"github.com/AkatukiSora/twitter-dore/internal/state"
 	templatepkg "github.com/AkatukiSora/twitter-dore/internal/template"
 	"github.com/AkatukiSora/twitter-dore/internal/ui"
 )
 
-var runPromptBuilder = defaultPromptFactory
+var (
+	runPromptBuilder = defaultPromptFactory
+	// runClock supplies the time for computed placeholders such as "{@today}".
+	runClock = time.Now
+)
 
 func newRunCmd() *cobra.Command {
 	var (
 		inputPath string
+		name      string
 		output    string
 		noEmpty   bool
 		quiet     bool
+
+		answersPath string
+		assignments []string
 	)
 
 	cmd := &cobra.Command{
@@ -29,7 +42,17 @@ func newRunCmd() *cobra.Command {
 				return errors.New("--in is required")
 			}
 
-			doc, err := templatepkg.LoadFile(inputPath)
+			var (
+				prompts prompter
+				err     error
+			)
+			if answersPath == "" && len(assignments) == 0 {
+				if prompts, err = runPromptBuilder(cmd); err != nil {
+					return err
+				}
+			}
+
+			doc, title, err := loadTemplate(inputPath, name, prompts)
 			if err != nil {
 				return err
 			}
@@ -41,33 +64,29 @@ func newRunCmd() *cobra.Command {
 				return err
 			}
 
-			session, err := templatepkg.NewSession(doc.Template)
+			session, err := doc.NewSession()
 			if err != nil {
 				return err
 			}
+			if err := setEnvironment(session, inputPath, title); err != nil {
+				return err
+			}
 
-			placeholders := session.Placeholders()
-			values := make([]string, len(placeholders))
-			prompter, err := runPromptBuilder(cmd)
+			answers, err := loadAnswers(answersPath, assignments)
 			if err != nil {
 				return err
 			}
 
-			styler := ui.NewStyler(getColorSettings(cmd))
 			allowEmpty := !noEmpty
 
-			for idx, placeholder := range placeholders {
-				highlighted := styler.HighlightLine(placeholder.Line)
-				if _, err := fmt.Fprintln(cmd.ErrOrStderr(), highlighted); err != nil {
-					return err
-				}
-
-				value, err := prompter.Ask(placeholder.Label, allowEmpty)
-				if err != nil {
-					return err
-				}
-
-				values[idx] = value
+			var values []templatepkg.Value
+			if answers != nil {
+				values, err = answers.resolve(session, allowEmpty)
+			} else {
+				values, err = askValues(cmd, prompts, session, allowEmpty)
+			}
+			if err != nil {
+				return err
 			}
 
 			result, err := session.Fill(values)
@@ -92,11 +111,228 @@ func newRunCmd() *cobra.Command {
 	}
 
 	cmd.Flags().StringVar(&inputPath, "in", "", "Path to template YAML")
+	cmd.Flags().StringVar(&name, "name", "", "Title of the template to fill when the file is a bundle")
 	cmd.Flags().StringVar(&output, "out", "", "Path to write filled template")
 	cmd.Flags().BoolVar(&noEmpty, "no-empty", false, "Require non-empty answers for placeholders")
 	cmd.Flags().BoolVar(&quiet, "quiet", false, "Suppress completed output")
+	cmd.Flags().StringVar(&answersPath, "answers", "", "Read answers from a YAML file instead of prompting")
+	cmd.Flags().StringArrayVar(&assignments, "set", nil, "Answer a placeholder without prompting (key=value, repeatable)")
 
 	_ = cmd.MarkFlagRequired("in")
 
 	return cmd
 }
+
+// setEnvironment backs the session's counters with the state file, keyed by
+// the absolute template path, and the title within a bundle, so that each
+// template counts on its own. The state file is only touched when the
+// template uses a counter.
+func setEnvironment(session *templatepkg.Session, inputPath, title string) error {
+	templatePath, err := filepath.Abs(inputPath)
+	if err != nil {
+		return err
+	}
+	if title != "" {
+		templatePath += "[" + title + "]"
+	}
+
+	session.SetEnvironment(templatepkg.Environment{
+		Now: runClock,
+		Counter: func(name string) (int, error) {
+			statePath, err := state.DefaultPath()
+			if err != nil {
+				return 0, err
+			}
+			key := templatePath
+			if name != "" {
+				key += "#" + name
+			}
+			return state.New(statePath).NextCounter(key)
+		},
+	})
+	return nil
+}
+
+// askValues prompts for each placeholder in order, printing the lines it
+// appears on as context. Computed placeholders and those hidden by earlier
+// answers are skipped.
+func askValues(cmd *cobra.Command, prompter prompter, session *templatepkg.Session, allowEmpty bool) ([]templatepkg.Value, error) {
+	placeholders := session.Placeholders()
+
+	styler := ui.NewStyler(getColorSettings(cmd))
+	values := make([]templatepkg.Value, len(placeholders))
+
+	for idx, placeholder := range placeholders {
+		if placeholder.Kind.IsComputed() || !session.Relevant(idx, values) {
+			continue
+		}
+
+		for _, occurrence := range placeholder.Occurrences {
+			highlighted := styler.HighlightLine(occurrence.Line)
+			if _, err := fmt.Fprintln(cmd.ErrOrStderr(), highlighted); err != nil {
+				return nil, err
+			}
+		}
+		if err := printHelp(cmd.ErrOrStderr(), placeholder); err != nil {
+			return nil, err
+		}
+
+		value, err := askValue(cmd.ErrOrStderr(), prompter, placeholder, allowEmpty && !placeholder.Required)
+		if err != nil {
+			return nil, err
+		}
+
+		values[idx] = value
+	}
+
+	return values, nil
+}
+
+// printHelp writes the hint and example of a placeholder under its context
+// lines, indented so that they read as notes rather than template text.
+func printHelp(w io.Writer, placeholder templatepkg.Placeholder) error {
+	if placeholder.Hint != "" {
+		if _, err := fmt.Fprintf(w, "  ヒント: %s\n", placeholder.Hint); err != nil {
+			return err
+		}
+	}
+	if placeholder.Example != "" {
+		if _, err := fmt.Fprintf(w, "  例: %s\n", placeholder.Example); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func askValue(w io.Writer, prompter prompter, placeholder templatepkg.Placeholder, allowEmpty bool) (templatepkg.Value, error) {
+	switch placeholder.Kind {
+	case templatepkg.KindChoice:
+		initial := 0
+		for i, choice := range placeholder.Choices {
+			if choice == placeholder.Default {
+				initial = i
+			}
+		}
+
+		idx, err := prompter.Select(placeholder.Label, placeholder.Choices, initial)
+		if err != nil {
+			return templatepkg.Value{}, err
+		}
+		return templatepkg.TextValue(placeholder.Choices[idx]), nil
+	case templatepkg.KindCheck:
+		defaults := make(map[string]bool)
+		for _, item := range placeholder.DefaultValue().Items {
+			defaults[item] = true
+		}
+		selected := make([]bool, len(placeholder.Choices))
+		for i, choice := range placeholder.Choices {
+			selected[i] = defaults[choice]
+		}
+
+		indexes, err := prompter.MultiSelect(placeholder.Label, placeholder.Choices, selected)
+		if err != nil {
+			return templatepkg.Value{}, err
+		}
+		items := make([]string, len(indexes))
+		for i, idx := range indexes {
+			items[i] = placeholder.Choices[idx]
+		}
+		return templatepkg.ListValue(items...), nil
+	case templatepkg.KindList:
+		return askList(prompter, placeholder, allowEmpty)
+	case templatepkg.KindMultiline:
+		return askMultiline(w, prompter, placeholder, allowEmpty)
+	default:
+		label := placeholder.Label
+		if placeholder.Kind.IsScale() {
+			label = fmt.Sprintf("%s (%d〜%d)", label, placeholder.Min, placeholder.Max)
+		}
+
+		value, err := prompter.Ask(question{
+			label:        label,
+			defaultValue: placeholder.Default,
+			allowEmpty:   allowEmpty,
+			validate: func(input string) error {
+				return placeholder.Validate(templatepkg.TextValue(input))
+			},
+		})
+		if err != nil {
+			return templatepkg.Value{}, err
+		}
+		return templatepkg.TextValue(value), nil
+	}
+}
+
+// askMultiline asks for a multi-line answer until it is valid, reopening the
+// editor with the rejected text so that it can be corrected.
+func askMultiline(w io.Writer, prompter prompter, placeholder templatepkg.Placeholder, allowEmpty bool) (templatepkg.Value, error) {
+	header := []string{placeholder.Label + " を入力してください。先頭の # の行は無視されます。"}
+	for _, occurrence := range placeholder.Occurrences {
+		header = append(header, "  "+occurrence.Line)
+	}
+	if placeholder.Hint != "" {
+		header = append(header, "ヒント: "+placeholder.Hint)
+	}
+
+	q := question{label: placeholder.Label, defaultValue: placeholder.Default, allowEmpty: allowEmpty}
+	for {
+		text, err := prompter.AskMultiline(q, header)
+		if err != nil {
+			return templatepkg.Value{}, err
+		}
+
+		value := templatepkg.TextValue(text)
+		switch {
+		case strings.TrimSpace(text) == "" && !allowEmpty:
+			err = errors.New("入力が必要です")
+		case text != "":
+			err = placeholder.Validate(value)
+		}
+		if err == nil {
+			return value, nil
+		}
+
+		if _, err := fmt.Fprintln(w, err); err != nil {
+			return templatepkg.Value{}, err
+		}
+		q.defaultValue = text
+	}
+}
+
+// askList collects list items one prompt at a time until an empty answer or
+// the end token, like the template line loop of the new command.
+func askList(prompter prompter, placeholder templatepkg.Placeholder, allowEmpty bool) (templatepkg.Value, error) {
+	defaults := placeholder.DefaultValue()
+	items := make([]string, 0)
+	for {
+		label := fmt.Sprintf("%s #%d (空 Enter か %s で終了)", placeholder.Label, len(items)+1, templateEndToken)
+		item, err := prompter.Ask(question{
+			label:      label,
+			allowEmpty: allowEmpty || len(items) > 0 || len(defaults.Items) > 0,
+			validate: func(input string) error {
+				if strings.TrimSpace(input) == "" || input == templateEndToken {
+					return nil
+				}
+				return placeholder.Validate(templatepkg.ListValue(input))
+			},
+		})
+		if err != nil {
+			return templatepkg.Value{}, err
+		}
+
+		if strings.TrimSpace(item) != "" && item != templateEndToken {
+			items = append(items, item)
+			continue
+		}
+
+		if len(items) > 0 {
+			return templatepkg.ListValue(items...), nil
+		}
+		if len(defaults.Items) > 0 {
+			return defaults, nil
+		}
+		if allowEmpty {
+			return templatepkg.ListValue(), nil
+		}
+	}
+}
@@ -3,13 +3,18 @@ package cmd
 import (
 	"bytes"
 	"errors"
+	"fmt"
 	"io"
+	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/spf13/cobra"
 
+	"github.com/AkatukiSora/twitter-dore/internal/state"
 	templatepkg "github.com/AkatukiSora/twitter-dore/internal/template"
 )
 
@@ -18,60 +23,1018 @@ type stubPrompter struct {
 	index     int
 }
 
-func (s *stubPrompter) Ask(_ string, allowEmpty bool) (string, error) {
+func (s *stubPrompter) Ask(q question) (string, error) {
 	for s.index < len(s.responses) {
 		value := s.responses[s.index]
 		s.index++
-		if !allowEmpty && value == "" {
+		if value == "" {
+			value = q.defaultValue
+		}
+		if !q.allowEmpty && value == "" {
+			continue
+		}
+		if q.validate != nil && value != "" && q.validate(value) != nil {
 			continue
 		}
 		return value, nil
 	}
-	return "", errors.New("no more stub responses")
+	return "", errors.New("no more stub responses")
+}
+
+// Select consumes the next response and picks the item with the same text;
+// an empty response keeps the initial cursor position.
+func (s *stubPrompter) Select(_ string, items []string, initial int) (int, error) {
+	if s.index >= len(s.responses) {
+		return 0, errors.New("no more stub responses")
+	}
+	value := s.responses[s.index]
+	s.index++
+	if value == "" {
+		return initial, nil
+	}
+	for idx, item := range items {
+		if item == value {
+			return idx, nil
+		}
+	}
+	return 0, fmt.Errorf("stub response %q is not one of %v", value, items)
+}
+
+// MultiSelect consumes the next response as a comma-separated list of items;
+// an empty response keeps the initial selection.
+func (s *stubPrompter) MultiSelect(_ string, items []string, selected []bool) ([]int, error) {
+	if s.index >= len(s.responses) {
+		return nil, errors.New("no more stub responses")
+	}
+	value := s.responses[s.index]
+	s.index++
+
+	indexes := make([]int, 0)
+	if value == "" {
+		for idx, checked := range selected {
+			if checked {
+				indexes = append(indexes, idx)
+			}
+		}
+		return indexes, nil
+	}
+
+	for _, want := range strings.Split(value, ",") {
+		found := false
+		for idx, item := range items {
+			if item == want {
+				indexes = append(indexes, idx)
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("stub response %q is not one of %v", want, items)
+		}
+	}
+	return indexes, nil
+}
+
+// AskMultiline consumes the next response, which may contain line breaks, as
+// the whole answer.
+func (s *stubPrompter) AskMultiline(q question, _ []string) (string, error) {
+	if s.index >= len(s.responses) {
+		return "", errors.New("no more stub responses")
+	}
+	value := s.responses[s.index]
+	s.index++
+	if value == "" {
+		value = q.defaultValue
+	}
+	return value, nil
+}
+
+func TestRunBasic(t *testing.T) {
+	withTerminal(t, false)
+	responses := []string{"Alice", "100"}
+	withRunPrompter(t, responses)
+
+	dir := t.TempDir()
+	templatePath := filepath.Join(dir, "tpl.yaml")
+	doc := templatepkg.Document{
+		Title:       "sample",
+		Description: "desc",
+		Template:    "呼び方: {}\n好感度: {}",
+	}
+	if err := templatepkg.WriteFile(templatePath, doc); err != nil {
+		t.Fatalf("write template: %v", err)
+	}
+
+	cmd := NewRootCmd()
+	outBuf := &bytes.Buffer{}
+	errBuf := &bytes.Buffer{}
+	cmd.SetOut(outBuf)
+	cmd.SetErr(errBuf)
+	cmd.SetArgs([]string{"run", "--in", templatePath})
+
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := "呼び方: Alice\n好感度: 100"
+	if outBuf.String() != expected {
+		t.Fatalf("unexpected output:\nwant: %q\nhave: %q", expected, outBuf.String())
+	}
+}
+
+func TestRunLiteralPlaceholders(t *testing.T) {
+	withTerminal(t, false)
+	responses := []string{"OK"}
+	withRunPrompter(t, responses)
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tpl.yaml")
+	doc := templatepkg.Document{
+		Template: "literal: {{}}\nvalue: {}",
+	}
+	if err := templatepkg.WriteFile(path, doc); err != nil {
+		t.Fatalf("write template: %v", err)
+	}
+
+	cmd := NewRootCmd()
+	outBuf := &bytes.Buffer{}
+	cmd.SetOut(outBuf)
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetArgs([]string{"run", "--in", path})
+
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("execute: %v", err)
+	}
+
+	expected := "literal: {}\nvalue: OK"
+	if outBuf.String() != expected {
+		t.Fatalf("unexpected output: want %q, got %q", expected, outBuf.String())
+	}
+}
+
+func TestRunEscapes(t *testing.T) {
+	withTerminal(t, false)
+	withRunPrompter(t, []string{"alice"})
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tpl.yaml")
+	doc := templatepkg.Document{
+		Template: `\{名前\} = {名前}\\ {{}} \{>footer\} \{$x\}\{/\}\n\ は そのまま`,
+	}
+	if err := templatepkg.WriteFile(path, doc); err != nil {
+		t.Fatalf("write template: %v", err)
+	}
+
+	cmd := NewRootCmd()
+	outBuf := &bytes.Buffer{}
+	cmd.SetOut(outBuf)
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetArgs([]string{"run", "--in", path})
+
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("execute: %v", err)
+	}
+
+	expected := `{名前} = alice\ {} {>footer} {$x}{/}\n\ は そのまま`
+	if outBuf.String() != expected {
+		t.Fatalf("unexpected output: want %q, got %q", expected, outBuf.String())
+	}
+
+	if got := decodeInline(`\{a\}\n\\n\t`); got != "\\{a\\}\n\\\\n\t" {
+		t.Fatalf("expected template escapes to survive decodeInline, got %q", got)
+	}
+}
+
+func TestRunAnswersAreNotReinterpreted(t *testing.T) {
+	withTerminal(t, false)
+	responses := []string{"{}", "__TWITTER_DORE_LITERAL_0__"}
+	withRunPrompter(t, responses)
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tpl.yaml")
+	doc := templatepkg.Document{
+		Template: "A: {}\nB: {}\nC: {{}}",
+	}
+	if err := templatepkg.WriteFile(path, doc); err != nil {
+		t.Fatalf("write template: %v", err)
+	}
+
+	cmd := NewRootCmd()
+	outBuf := &bytes.Buffer{}
+	cmd.SetOut(outBuf)
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetArgs([]string{"run", "--in", path})
+
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("execute: %v", err)
+	}
+
+	expected := "A: {}\nB: __TWITTER_DORE_LITERAL_0__\nC: {}"
+	if outBuf.String() != expected {
+		t.Fatalf("unexpected output: want %q, got %q", expected, outBuf.String())
+	}
+}
+
+func TestRunNamedPlaceholders(t *testing.T) {
+	withTerminal(t, false)
+	responses := []string{"アリス", "100"}
+	withRunPrompter(t, responses)
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tpl.yaml")
+	doc := templatepkg.Document{
+		Template: "{name}へ\n好感度: {}\nまたね{name}",
+	}
+	if err := templatepkg.WriteFile(path, doc); err != nil {
+		t.Fatalf("write template: %v", err)
+	}
+
+	cmd := NewRootCmd()
+	outBuf := &bytes.Buffer{}
+	cmd.SetOut(outBuf)
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetArgs([]string{"run", "--in", path})
+
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("execute: %v", err)
+	}
+
+	expected := "アリスへ\n好感度: 100\nまたねアリス"
+	if outBuf.String() != expected {
+		t.Fatalf("unexpected output: want %q, got %q", expected, outBuf.String())
+	}
+}
+
+func TestRunDefaults(t *testing.T) {
+	withTerminal(t, false)
+	responses := []string{"", "Alice"}
+	withRunPrompter(t, responses)
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tpl.yaml")
+	doc := templatepkg.Document{
+		Template: "好感度: {好感度=100}\n呼び方: {呼び方}",
+		Fields: map[string]templatepkg.Field{
+			"呼び方": {Default: "ちゃん"},
+		},
+	}
+	if err := templatepkg.WriteFile(path, doc); err != nil {
+		t.Fatalf("write template: %v", err)
+	}
+
+	cmd := NewRootCmd()
+	outBuf := &bytes.Buffer{}
+	cmd.SetOut(outBuf)
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetArgs([]string{"run", "--in", path, "--no-empty"})
+
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("execute: %v", err)
+	}
+
+	expected := "好感度: 100\n呼び方: Alice"
+	if outBuf.String() != expected {
+		t.Fatalf("unexpected output: want %q, got %q", expected, outBuf.String())
+	}
+
+	session, err := templatepkg.NewSession("{site=https://example.com} {t=12:00} {評価=b: a|b}")
+	if err != nil {
+		t.Fatalf("expected colons in defaults to parse, got %v", err)
+	}
+	for i, want := range []string{"https://example.com", "12:00", "b"} {
+		if got := session.Placeholders()[i].Default; got != want {
+			t.Fatalf("unexpected default %d: want %q, got %q", i, want, got)
+		}
+	}
+	if kind := session.Placeholders()[2].Kind; kind != templatepkg.KindChoice {
+		t.Fatalf("expected a spec after a default to apply, got %s", kind)
+	}
+}
+
+func TestRunNonInteractiveAnswers(t *testing.T) {
+	withTerminal(t, false)
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tpl.yaml")
+	doc := templatepkg.Document{
+		Template: "呼び方: {}\n好感度: {好感度=100}\n一言: {一言}",
+	}
+	if err := templatepkg.WriteFile(path, doc); err != nil {
+		t.Fatalf("write template: %v", err)
+	}
+
+	answersPath := filepath.Join(dir, "answers.yaml")
+	if err := os.WriteFile(answersPath, []byte("呼び方: Alice\n一言: よろしく\n"), 0o644); err != nil {
+		t.Fatalf("write answers: %v", err)
+	}
+
+	cmd := NewRootCmd()
+	outBuf := &bytes.Buffer{}
+	cmd.SetOut(outBuf)
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetArgs([]string{"run", "--in", path, "--answers", answersPath, "--set", "一言=またね"})
+
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("execute: %v", err)
+	}
+
+	expected := "呼び方: Alice\n好感度: 100\n一言: またね"
+	if outBuf.String() != expected {
+		t.Fatalf("unexpected output: want %q, got %q", expected, outBuf.String())
+	}
+
+	cmd = NewRootCmd()
+	cmd.SetOut(&bytes.Buffer{})
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetArgs([]string{"run", "--in", path, "--set", "好感ど=1"})
+
+	err := cmd.Execute()
+	if err == nil || !strings.Contains(err.Error(), "好感ど") {
+		t.Fatalf("expected unknown key error, got %v", err)
+	}
+}
+
+func TestRunChoices(t *testing.T) {
+	withTerminal(t, false)
+	responses := []string{"FF外", ""}
+	withRunPrompter(t, responses)
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tpl.yaml")
+	doc := templatepkg.Document{
+		Template: "関係性: {関係性: 相互|FF外|リア友}\n印象: {印象}",
+		Fields: map[string]templatepkg.Field{
+			"印象": {Choices: []string{"優しい", "面白い"}, Default: "面白い"},
+		},
+	}
+	if err := templatepkg.WriteFile(path, doc); err != nil {
+		t.Fatalf("write template: %v", err)
+	}
+
+	cmd := NewRootCmd()
+	outBuf := &bytes.Buffer{}
+	cmd.SetOut(outBuf)
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetArgs([]string{"run", "--in", path})
+
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("execute: %v", err)
+	}
+
+	expected := "関係性: FF外\n印象: 面白い"
+	if outBuf.String() != expected {
+		t.Fatalf("unexpected output: want %q, got %q", expected, outBuf.String())
+	}
+
+	cmd = NewRootCmd()
+	cmd.SetOut(&bytes.Buffer{})
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetArgs([]string{"run", "--in", path, "--set", "関係性=友達"})
+
+	err := cmd.Execute()
+	if err == nil || !strings.Contains(err.Error(), "友達") {
+		t.Fatalf("expected invalid choice error, got %v", err)
+	}
+}
+
+func TestRunChecklist(t *testing.T) {
+	withTerminal(t, false)
+	responses := []string{"優しい,かわいい"}
+	withRunPrompter(t, responses)
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tpl.yaml")
+	doc := templatepkg.Document{
+		Template: "当てはまるもの: {当てはまるもの: check 優しい|面白い|かわいい}",
+		Markers:  templatepkg.Markers{Checked: "■", Unchecked: "□"},
+	}
+	if err := templatepkg.WriteFile(path, doc); err != nil {
+		t.Fatalf("write template: %v", err)
+	}
+
+	cmd := NewRootCmd()
+	outBuf := &bytes.Buffer{}
+	cmd.SetOut(outBuf)
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetArgs([]string{"run", "--in", path})
+
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("execute: %v", err)
+	}
+
+	expected := "当てはまるもの: ■優しい □面白い ■かわいい"
+	if outBuf.String() != expected {
+		t.Fatalf("unexpected output: want %q, got %q", expected, outBuf.String())
+	}
+
+	answersPath := filepath.Join(dir, "answers.yaml")
+	if err := os.WriteFile(answersPath, []byte("当てはまるもの:\n  - 面白い\n"), 0o644); err != nil {
+		t.Fatalf("write answers: %v", err)
+	}
+
+	cmd = NewRootCmd()
+	outBuf = &bytes.Buffer{}
+	cmd.SetOut(outBuf)
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetArgs([]string{"run", "--in", path, "--answers", answersPath})
+
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("execute with answers: %v", err)
+	}
+
+	expected = "当てはまるもの: □優しい ■面白い □かわいい"
+	if outBuf.String() != expected {
+		t.Fatalf("unexpected output: want %q, got %q", expected, outBuf.String())
+	}
+}
+
+func TestRunScales(t *testing.T) {
+	withTerminal(t, false)
+	responses := []string{"11", "7", "3", "", "50"}
+	withRunPrompter(t, responses)
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tpl.yaml")
+	gaugeMax := 4
+	doc := templatepkg.Document{
+		Template: "好感度: {好感度: gauge 0..10 ■□}\n評価: {評価: stars}\nやる気: {やる気=1}\n達成: {達成: percent 0..200}",
+		Fields: map[string]templatepkg.Field{
+			"やる気": {Type: templatepkg.KindGauge, Max: &gaugeMax, Symbols: "●○"},
+		},
+	}
+	if err := templatepkg.WriteFile(path, doc); err != nil {
+		t.Fatalf("write template: %v", err)
+	}
+
+	cmd := NewRootCmd()
+	outBuf := &bytes.Buffer{}
+	cmd.SetOut(outBuf)
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetArgs([]string{"run", "--in", path})
+
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("execute: %v", err)
+	}
+
+	expected := "好感度: ■■■■■■■□□□\n評価: ★★★☆☆\nやる気: ●○○○\n達成: 25%"
+	if outBuf.String() != expected {
+		t.Fatalf("unexpected output: want %q, got %q", expected, outBuf.String())
+	}
+
+	cmd = NewRootCmd()
+	cmd.SetOut(&bytes.Buffer{})
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetArgs([]string{"run", "--in", path, "--set", "好感度=12"})
+
+	err := cmd.Execute()
+	if err == nil || !strings.Contains(err.Error(), "好感度") {
+		t.Fatalf("expected out of range error, got %v", err)
+	}
+
+	negative := -5
+	doc = templatepkg.Document{
+		Template: "{x}",
+		Fields:   map[string]templatepkg.Field{"x": {Type: templatepkg.KindStars, Min: &negative}},
+	}
+	if err := doc.Validate(); err == nil || !strings.Contains(err.Error(), `field "x": invalid range -5..5 for stars`) {
+		t.Fatalf("expected a declared range to be checked, got %v", err)
+	}
+}
+
+func TestRunTypedPlaceholders(t *testing.T) {
+	withTerminal(t, false)
+	responses := []string{"二十", "20", "2000-13-01", "1/2", "alice", "example.com", "https://example.com"}
+	withRunPrompter(t, responses)
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tpl.yaml")
+	doc := templatepkg.Document{
+		Template: "年齢: {年齢:int}\n誕生日: {誕生日:date}\nID: {@id}\nURL: {url}",
+	}
+	if err := templatepkg.WriteFile(path, doc); err != nil {
+		t.Fatalf("write template: %v", err)
+	}
+
+	cmd := NewRootCmd()
+	outBuf := &bytes.Buffer{}
+	cmd.SetOut(outBuf)
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetArgs([]string{"run", "--in", path})
+
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("execute: %v", err)
+	}
+
+	expected := "年齢: 20\n誕生日: 1/2\nID: @alice\nURL: https://example.com"
+	if outBuf.String() != expected {
+		t.Fatalf("unexpected output: want %q, got %q", expected, outBuf.String())
+	}
+
+	answersPath := filepath.Join(dir, "answers.yaml")
+	if err := os.WriteFile(answersPath, []byte("年齢: 20\n誕生日: 2000-01-02\nid: bad handle!\n"), 0o644); err != nil {
+		t.Fatalf("write answers: %v", err)
+	}
+
+	cmd = NewRootCmd()
+	cmd.SetOut(&bytes.Buffer{})
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetArgs([]string{"run", "--in", path, "--answers", answersPath})
+
+	err := cmd.Execute()
+	if err == nil || !strings.Contains(err.Error(), answersPath+":3: id:") {
+		t.Fatalf("expected error pointing at the answers file line, got %v", err)
+	}
+
+	cmd = NewRootCmd()
+	cmd.SetOut(&bytes.Buffer{})
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetArgs([]string{"run", "--in", path, "--set", "年齢=abc"})
+
+	err = cmd.Execute()
+	if err == nil || !strings.Contains(err.Error(), "--set 年齢: 年齢:") {
+		t.Fatalf("expected error pointing at the flag, got %v", err)
+	}
+}
+
+func TestRunConstraints(t *testing.T) {
+	withTerminal(t, false)
+	responses := []string{"ありがとうございました", "ありがとう", "abc", "A12"}
+	withRunPrompter(t, responses)
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tpl.yaml")
+	doc := templatepkg.Document{
+		Template: "一言: {一言}\nコード: {コード}",
+		Fields: map[string]templatepkg.Field{
+			"一言":  {MaxLength: 5, Message: "一言は5文字以内で"},
+			"コード": {Pattern: `[A-Z][0-9]+`},
+		},
+	}
+	if err := templatepkg.WriteFile(path, doc); err != nil {
+		t.Fatalf("write template: %v", err)
+	}
+
+	cmd := NewRootCmd()
+	outBuf := &bytes.Buffer{}
+	cmd.SetOut(outBuf)
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetArgs([]string{"run", "--in", path})
+
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("execute: %v", err)
+	}
+
+	expected := "一言: ありがとう\nコード: A12"
+	if outBuf.String() != expected {
+		t.Fatalf("unexpected output: want %q, got %q", expected, outBuf.String())
+	}
+
+	cmd = NewRootCmd()
+	cmd.SetOut(&bytes.Buffer{})
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetArgs([]string{"run", "--in", path, "--set", "一言=ありがとうございました"})
+
+	err := cmd.Execute()
+	if err == nil || !strings.Contains(err.Error(), "一言は5文字以内で") {
+		t.Fatalf("expected custom constraint message, got %v", err)
+	}
+}
+
+func TestRunLabelsAndHints(t *testing.T) {
+	withTerminal(t, false)
+	withRunPrompter(t, []string{"80", "", "またね"})
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tpl.yaml")
+	doc := templatepkg.Document{
+		Template: "・{label=\"好感度\" hint=\"0〜100で\"}\n名前: {名前=アリス example=\"アリス\"}\n{一言}",
+		Fields: map[string]templatepkg.Field{
+			"一言": {Label: "ひとこと", Hint: "20文字以内"},
+		},
+	}
+	if err := templatepkg.WriteFile(path, doc); err != nil {
+		t.Fatalf("write template: %v", err)
+	}
+
+	session, err := doc.NewSession()
+	if err != nil {
+		t.Fatalf("new session: %v", err)
+	}
+	labels := make([]string, 0)
+	for _, placeholder := range session.Placeholders() {
+		labels = append(labels, placeholder.Label)
+	}
+	if want := []string{"好感度", "名前", "ひとこと"}; strings.Join(labels, ",") != strings.Join(want, ",") {
+		t.Fatalf("unexpected labels: want %v, got %v", want, labels)
+	}
+
+	cmd := NewRootCmd()
+	outBuf := &bytes.Buffer{}
+	errBuf := &bytes.Buffer{}
+	cmd.SetOut(outBuf)
+	cmd.SetErr(errBuf)
+	cmd.SetArgs([]string{"run", "--in", path})
+
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("execute: %v", err)
+	}
+
+	expected := "・80\n名前: アリス\nまたね"
+	if outBuf.String() != expected {
+		t.Fatalf("unexpected output: want %q, got %q", expected, outBuf.String())
+	}
+	for _, want := range []string{"ヒント: 0〜100で", "例: アリス", "ヒント: 20文字以内"} {
+		if !strings.Contains(errBuf.String(), want) {
+			t.Fatalf("expected stderr to contain %q, got:\n%s", want, errBuf.String())
+		}
+	}
+
+	cmd = NewRootCmd()
+	outBuf = &bytes.Buffer{}
+	cmd.SetOut(outBuf)
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetArgs([]string{"run", "--in", path, "--set", "好感度=10", "--set", "一言=x"})
+
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("execute with answers: %v", err)
+	}
+	if expected := "・10\n名前: アリス\nx"; outBuf.String() != expected {
+		t.Fatalf("expected anonymous placeholders to be answered by label: want %q, got %q", expected, outBuf.String())
+	}
+
+	_, err = templatepkg.NewSession(`{a hint="x" hint="y"}`)
+	if err == nil || !strings.Contains(err.Error(), `attribute "hint" is given more than once`) {
+		t.Fatalf("expected duplicate attribute error, got %v", err)
+	}
+}
+
+func TestRunMultiline(t *testing.T) {
+	withTerminal(t, false)
+	withRunPrompter(t, []string{"長すぎる\n回答ですよ。本当にね", "一行目\n\n  三行目 \n"})
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tpl.yaml")
+	doc := templatepkg.Document{
+		Template: "一言:\n{一言:multiline}\n以上",
+		Fields:   map[string]templatepkg.Field{"一言": {MaxLength: 12}},
+	}
+	if err := templatepkg.WriteFile(path, doc); err != nil {
+		t.Fatalf("write template: %v", err)
+	}
+
+	cmd := NewRootCmd()
+	outBuf := &bytes.Buffer{}
+	errBuf := &bytes.Buffer{}
+	cmd.SetOut(outBuf)
+	cmd.SetErr(errBuf)
+	cmd.SetArgs([]string{"run", "--in", path})
+
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("execute: %v", err)
+	}
+
+	expected := "一言:\n一行目\n\n  三行目 \n\n以上"
+	if outBuf.String() != expected {
+		t.Fatalf("unexpected output: want %q, got %q", expected, outBuf.String())
+	}
+	if !strings.Contains(errBuf.String(), "must be at most 12 characters") {
+		t.Fatalf("expected the rejected answer to be reported, got:\n%s", errBuf.String())
+	}
+}
+
+func TestEditText(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake editor is a shell script")
+	}
+
+	dir := t.TempDir()
+	editor := filepath.Join(dir, "editor.sh")
+	script := "#!/bin/sh\nprintf '#推し活\\n1行目\\n\\n# 本文の#\\n' >> \"$1\"\n"
+	if err := os.WriteFile(editor, []byte(script), 0o755); err != nil {
+		t.Fatalf("write editor: %v", err)
+	}
+
+	text, err := editText([]string{editor}, []string{"一言 を入力してください。"}, "", nil, io.Discard, io.Discard)
+	if err != nil {
+		t.Fatalf("edit: %v", err)
+	}
+	if want := "#推し活\n1行目\n\n# 本文の#"; text != want {
+		t.Fatalf("unexpected text: want %q, got %q", want, text)
+	}
+
+	screen := filepath.Join(dir, "screen.sh")
+	script = "#!/bin/sh\necho 画面\nprintf 'ok' >> \"$1\"\n"
+	if err := os.WriteFile(screen, []byte(script), 0o755); err != nil {
+		t.Fatalf("write editor: %v", err)
+	}
+	t.Setenv("VISUAL", screen)
+	errBuf := &bytes.Buffer{}
+	prompts := &promptUIPrompter{reader: io.NopCloser(strings.NewReader("")), writer: nopWriteCloser{Writer: errBuf}}
+	text, err = prompts.AskMultiline(question{label: "一言"}, nil)
+	if err != nil {
+		t.Fatalf("ask: %v", err)
+	}
+	if text != "ok" || errBuf.String() != "画面\n" {
+		t.Fatalf("expected the editor to draw on the prompt writer, got %q and %q", text, errBuf.String())
+	}
+}
+
+func TestRunDeclaredFields(t *testing.T) {
+	withTerminal(t, false)
+	withRunPrompter(t, []string{"", "alice", "90"})
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tpl.yaml")
+	doc := templatepkg.Document{
+		Template: "{名前}さん 好感度{好感度}",
+		Fields: map[string]templatepkg.Field{
+			"名前":  {Label: "お名前", Required: true},
+			"好感度": {Type: templatepkg.KindInt, Label: "好感度 (0〜100)", Default: "50"},
+		},
+	}
+	if err := templatepkg.WriteFile(path, doc); err != nil {
+		t.Fatalf("write template: %v", err)
+	}
+
+	cmd := NewRootCmd()
+	outBuf := &bytes.Buffer{}
+	cmd.SetOut(outBuf)
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetArgs([]string{"run", "--in", path})
+
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("execute: %v", err)
+	}
+	if expected := "aliceさん 好感度90"; outBuf.String() != expected {
+		t.Fatalf("unexpected output: want %q, got %q", expected, outBuf.String())
+	}
+
+	cmd = NewRootCmd()
+	cmd.SetOut(&bytes.Buffer{})
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetArgs([]string{"run", "--in", path, "--set", "好感度=1"})
+	if err := cmd.Execute(); err == nil || !strings.Contains(err.Error(), `no answer for "お名前"`) {
+		t.Fatalf("expected a required field to need an answer, got %v", err)
+	}
+
+	doc = templatepkg.Document{
+		Template: "{x: check a|b}\n{#好き}・{}\n{/}",
+		Fields: map[string]templatepkg.Field{
+			"x":  {Choices: []string{"a", "b", "c"}},
+			"好き": {Choices: []string{"猫", "犬"}},
+		},
+	}
+	if err := templatepkg.WriteFile(path, doc); err != nil {
+		t.Fatalf("write template: %v", err)
+	}
+	cmd = NewRootCmd()
+	outBuf = &bytes.Buffer{}
+	cmd.SetOut(outBuf)
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetArgs([]string{"run", "--in", path, "--set", "x=a,c", "--set", "好き=猫,犬"})
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("expected choices in fields to keep lists multiple, got %v", err)
+	}
+	if expected := "☑a ☐b ☑c\n・猫\n・犬"; outBuf.String() != expected {
+		t.Fatalf("unexpected output: want %q, got %q", expected, outBuf.String())
+	}
+
+	tests := []struct {
+		doc     templatepkg.Document
+		message string
+	}{
+		{
+			templatepkg.Document{Template: "{名前}", Fields: map[string]templatepkg.Field{"名前": {}, "なまえ": {}}},
+			`field "なまえ" is declared but not used in the template`,
+		},
+		{
+			templatepkg.Document{Template: "{a} {b}", Fields: map[string]templatepkg.Field{"a": {Label: "名前"}, "b": {Label: "名前"}}},
+			`1:5: label "名前" is also used by the placeholder at 1:1`,
+		},
+		{
+			templatepkg.Document{Template: "{a}", Fields: map[string]templatepkg.Field{"a": {Required: true, Optional: true}}},
+			"required and optional cannot both be set",
+		},
+		{
+			templatepkg.Document{Template: "{a}", Fields: map[string]templatepkg.Field{"a": {Type: "bogus"}}},
+			`field "a": unknown type "bogus" (available: text, choice,`,
+		},
+		{
+			templatepkg.Document{Template: "{a}", Fields: map[string]templatepkg.Field{"a": {Type: templatepkg.KindComputed}}},
+			`field "a": unknown type "computed"`,
+		},
+		{
+			templatepkg.Document{Template: "{a}", Fields: map[string]templatepkg.Field{"a": {Type: templatepkg.KindChoice}}},
+			`field "a": type choice needs choices`,
+		},
+	}
+	for _, tt := range tests {
+		if err := tt.doc.Validate(); err == nil || !strings.Contains(err.Error(), tt.message) {
+			t.Fatalf("expected %q, got %v", tt.message, err)
+		}
+	}
+
+	problems, err := templatepkg.Document{
+		Template: "{a} {b} A: {} A: {}",
+		Fields:   map[string]templatepkg.Field{"a": {}},
+	}.Check()
+	if err != nil {
+		t.Fatalf("check: %v", err)
+	}
+	messages := make([]string, 0, len(problems))
+	for _, problem := range problems {
+		if problem.Severity != templatepkg.SeverityWarning {
+			t.Fatalf("expected only warnings, got %v", problem)
+		}
+		messages = append(messages, problem.Error())
+	}
+	want := `1:5: placeholder "b" is not declared in fields; 1:18: label "A:" is also used by the placeholder at 1:12`
+	if strings.Join(messages, "; ") != want {
+		t.Fatalf("unexpected problems: want %q, got %q", want, strings.Join(messages, "; "))
+	}
+}
+
+func TestRunConditionalSections(t *testing.T) {
+	withTerminal(t, false)
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tpl.yaml")
+	doc := templatepkg.Document{
+		Template: "呼び方: {呼び方}\n{?推しがいる}推し: {推し}{/}\n{?関係性=相互}\nいつもありがとう\n{/}\n関係性: {関係性: 相互|FF外}",
+	}
+	if err := templatepkg.WriteFile(path, doc); err != nil {
+		t.Fatalf("write template: %v", err)
+	}
+
+	cases := []struct {
+		name      string
+		responses []string
+		expected  string
+	}{
+		{
+			name:      "shown",
+			responses: []string{"Alice", "はい", "Bob", "相互"},
+			expected:  "呼び方: Alice\n推し: Bob\nいつもありがとう\n関係性: 相互",
+		},
+		{
+			// The 推し prompt is skipped, so only three responses are needed.
+			name:      "hidden",
+			responses: []string{"Alice", "いいえ", "FF外"},
+			expected:  "呼び方: Alice\n関係性: FF外",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			withRunPrompter(t, tc.responses)
+
+			cmd := NewRootCmd()
+			outBuf := &bytes.Buffer{}
+			cmd.SetOut(outBuf)
+			cmd.SetErr(&bytes.Buffer{})
+			cmd.SetArgs([]string{"run", "--in", path})
+
+			if err := cmd.Execute(); err != nil {
+				t.Fatalf("execute: %v", err)
+			}
+
+			if outBuf.String() != tc.expected {
+				t.Fatalf("unexpected output: want %q, got %q", tc.expected, outBuf.String())
+			}
+		})
+	}
+}
+
+func TestRunOptionalLines(t *testing.T) {
+	withTerminal(t, false)
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tpl.yaml")
+	doc := templatepkg.Document{
+		Template: "呼び方: {呼び方}\n推し: {推し?}\n{推し?}と{相方}\n一言: {一言}\n趣味: {趣味}",
+		Fields:   map[string]templatepkg.Field{"一言": {Optional: true}},
+	}
+	if err := templatepkg.WriteFile(path, doc); err != nil {
+		t.Fatalf("write template: %v", err)
+	}
+
+	run := func(args ...string) string {
+		cmd := NewRootCmd()
+		outBuf := &bytes.Buffer{}
+		cmd.SetOut(outBuf)
+		cmd.SetErr(&bytes.Buffer{})
+		cmd.SetArgs(append([]string{"run", "--in", path}, args...))
+		if err := cmd.Execute(); err != nil {
+			t.Fatalf("execute: %v", err)
+		}
+		return outBuf.String()
+	}
+
+	expected := "呼び方: alice\nと\n趣味: "
+	if got := run("--set", "呼び方=alice"); got != expected {
+		t.Fatalf("unexpected output: want %q, got %q", expected, got)
+	}
+
+	expected = "呼び方: alice\n推し: bob\nbobと\n一言: hi\n趣味: "
+	if got := run("--set", "呼び方=alice", "--set", "推し=bob", "--set", "一言=hi"); got != expected {
+		t.Fatalf("unexpected output: want %q, got %q", expected, got)
+	}
+
+	doc.Optional = true
+	if err := templatepkg.WriteFile(path, doc); err != nil {
+		t.Fatalf("write template: %v", err)
+	}
+	expected = "呼び方: alice"
+	if got := run("--set", "呼び方=alice"); got != expected {
+		t.Fatalf("expected the template option to drop every emptied line: want %q, got %q", expected, got)
+	}
 }
 
-func TestRunBasic(t *testing.T) {
+func TestRunRepeatingSections(t *testing.T) {
 	withTerminal(t, false)
-	responses := []string{"Alice", "100"}
+	responses := []string{"優しい", "面白い", templateEndToken, "またね"}
 	withRunPrompter(t, responses)
 
 	dir := t.TempDir()
-	templatePath := filepath.Join(dir, "tpl.yaml")
+	path := filepath.Join(dir, "tpl.yaml")
 	doc := templatepkg.Document{
-		Title:       "sample",
-		Description: "desc",
-		Template:    "呼び方: {}\n好感度: {}",
+		Template: "好きなところ:\n{#好きなところ}・{}\n{/}\n一言: {一言}",
 	}
-	if err := templatepkg.WriteFile(templatePath, doc); err != nil {
+	if err := templatepkg.WriteFile(path, doc); err != nil {
 		t.Fatalf("write template: %v", err)
 	}
 
 	cmd := NewRootCmd()
 	outBuf := &bytes.Buffer{}
-	errBuf := &bytes.Buffer{}
 	cmd.SetOut(outBuf)
-	cmd.SetErr(errBuf)
-	cmd.SetArgs([]string{"run", "--in", templatePath})
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetArgs([]string{"run", "--in", path})
 
 	if err := cmd.Execute(); err != nil {
-		t.Fatalf("unexpected error: %v", err)
+		t.Fatalf("execute: %v", err)
 	}
 
-	expected := "呼び方: Alice\n好感度: 100"
+	expected := "好きなところ:\n・優しい\n・面白い\n一言: またね"
 	if outBuf.String() != expected {
-		t.Fatalf("unexpected output:\nwant: %q\nhave: %q", expected, outBuf.String())
+		t.Fatalf("unexpected output: want %q, got %q", expected, outBuf.String())
+	}
+
+	answersPath := filepath.Join(dir, "answers.yaml")
+	if err := os.WriteFile(answersPath, []byte("好きなところ: [声, 絵, 文章]\n一言: よろしく\n"), 0o644); err != nil {
+		t.Fatalf("write answers: %v", err)
+	}
+
+	cmd = NewRootCmd()
+	outBuf = &bytes.Buffer{}
+	cmd.SetOut(outBuf)
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetArgs([]string{"run", "--in", path, "--answers", answersPath})
+
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("execute with answers: %v", err)
+	}
+
+	expected = "好きなところ:\n・声\n・絵\n・文章\n一言: よろしく"
+	if outBuf.String() != expected {
+		t.Fatalf("unexpected output: want %q, got %q", expected, outBuf.String())
+	}
+
+	withRunPrompter(t, []string{"ABCDEFGHIJ", "abc", "12345", templateEndToken, "またね"})
+	doc.Fields = map[string]templatepkg.Field{"好きなところ": {MaxLength: 3, Pattern: "[a-z]+"}}
+	if err := templatepkg.WriteFile(path, doc); err != nil {
+		t.Fatalf("write template: %v", err)
+	}
+
+	cmd = NewRootCmd()
+	outBuf = &bytes.Buffer{}
+	cmd.SetOut(outBuf)
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetArgs([]string{"run", "--in", path})
+
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("execute with constraints: %v", err)
+	}
+	if expected = "好きなところ:\n・abc\n一言: またね"; outBuf.String() != expected {
+		t.Fatalf("expected items to be checked one by one: want %q, got %q", expected, outBuf.String())
+	}
+
+	cmd = NewRootCmd()
+	cmd.SetOut(&bytes.Buffer{})
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetArgs([]string{"run", "--in", path, "--set", "好きなところ=abc,ABCDEFGHIJ", "--set", "一言=x"})
+	if err := cmd.Execute(); err == nil || !strings.Contains(err.Error(), "must be at most 3 characters") {
+		t.Fatalf("expected an item over the limit to be rejected, got %v", err)
 	}
 }
 
-func TestRunLiteralPlaceholders(t *testing.T) {
+func TestRunFilters(t *testing.T) {
 	withTerminal(t, false)
-	responses := []string{"OK"}
-	withRunPrompter(t, responses)
 
 	dir := t.TempDir()
 	path := filepath.Join(dir, "tpl.yaml")
 	doc := templatepkg.Document{
-		Template: "literal: {{}}\nvalue: {}",
+		Template: "{name | trim | upper | fullwidth}\n{name | quote}\n{よみ | katakana}\n{ｶﾅ | fullwidth}\n" +
+			"{一言 | truncate 5 …}\n{#好き}{ | halfwidth}{/}\n{ヨミ | hiragana}\n{長文 | wrap 3}\n" +
+			"{関係性: 相互 | FF外 | リア友 | quote}",
 	}
 	if err := templatepkg.WriteFile(path, doc); err != nil {
 		t.Fatalf("write template: %v", err)
@@ -81,16 +1044,469 @@ func TestRunLiteralPlaceholders(t *testing.T) {
 	outBuf := &bytes.Buffer{}
 	cmd.SetOut(outBuf)
 	cmd.SetErr(&bytes.Buffer{})
-	cmd.SetArgs([]string{"run", "--in", path})
+	cmd.SetArgs([]string{
+		"run", "--in", path,
+		"--set", "name= alice ",
+		"--set", "よみ=ありす",
+		"--set", "ｶﾅ=ｶﾞｯﾎﾟ",
+		"--set", "一言=ありがとうございました",
+		"--set", "好き=ＡＢ,Ｃ",
+		"--set", "ヨミ=アリス",
+		"--set", "長文=あいうえおか",
+		"--set", "関係性=FF外",
+	})
 
 	if err := cmd.Execute(); err != nil {
 		t.Fatalf("execute: %v", err)
 	}
 
-	expected := "literal: {}\nvalue: OK"
+	expected := "ＡＬＩＣＥ\n「 alice 」\nアリス\nガッポ\nありがと…\nABC\nありす\nあいう\nえおか\n「FF外」"
+	if outBuf.String() != expected {
+		t.Fatalf("unexpected output: want %q, got %q", expected, outBuf.String())
+	}
+
+	if err := templatepkg.WriteFile(path, templatepkg.Document{Template: "A\nB: {name | uper}"}); err != nil {
+		t.Fatalf("write template: %v", err)
+	}
+
+	cmd = NewRootCmd()
+	cmd.SetOut(&bytes.Buffer{})
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetArgs([]string{"run", "--in", path, "--set", "name=x"})
+
+	err := cmd.Execute()
+	if err == nil || !strings.Contains(err.Error(), `2:12: unknown filter "uper"`) {
+		t.Fatalf("expected unknown filter error with position, got %v", err)
+	}
+
+	session, err := templatepkg.NewSession("{関係性: 相互 | FF外 | リア友}")
+	if err != nil {
+		t.Fatalf("expected spaced choices to parse, got %v", err)
+	}
+	if choices := session.Placeholders()[0].Choices; strings.Join(choices, ",") != "相互,FF外,リア友" {
+		t.Fatalf("unexpected choices: %v", choices)
+	}
+}
+
+func TestRunComputedPlaceholders(t *testing.T) {
+	withTerminal(t, false)
+	withRunPrompter(t, []string{"alice"})
+	withRunClock(t, time.Date(2024, 3, 9, 18, 30, 0, 0, time.UTC))
+	t.Setenv(state.PathEnv, filepath.Join(t.TempDir(), "state.json"))
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tpl.yaml")
+	doc := templatepkg.Document{
+		Template: "No.{@counter} {name}\n{@today} ({@weekday}) {@time}\n{@today:1月2日} {@weekday:en}\n{@random:7..7} {@counter}",
+	}
+	if err := templatepkg.WriteFile(path, doc); err != nil {
+		t.Fatalf("write template: %v", err)
+	}
+
+	run := func(args ...string) string {
+		cmd := NewRootCmd()
+		outBuf := &bytes.Buffer{}
+		cmd.SetOut(outBuf)
+		cmd.SetErr(&bytes.Buffer{})
+		cmd.SetArgs(append([]string{"run", "--in", path}, args...))
+		if err := cmd.Execute(); err != nil {
+			t.Fatalf("execute: %v", err)
+		}
+		return outBuf.String()
+	}
+
+	expected := "No.1 alice\n2024/03/09 (土) 18:30\n3月9日 Sat\n7 1"
+	if got := run(); got != expected {
+		t.Fatalf("unexpected output: want %q, got %q", expected, got)
+	}
+
+	expected = "No.2 bob\n2024/03/09 (土) 18:30\n3月9日 Sat\n7 2"
+	if got := run("--set", "name=bob"); got != expected {
+		t.Fatalf("expected the counter to persist: want %q, got %q", expected, got)
+	}
+
+	session, err := templatepkg.NewSession(doc.Template)
+	if err != nil {
+		t.Fatalf("new session: %v", err)
+	}
+	prompted := 0
+	for _, placeholder := range session.Placeholders() {
+		if !placeholder.Kind.IsComputed() {
+			prompted++
+		}
+	}
+	if prompted != 1 {
+		t.Fatalf("expected only name to be prompted, got %d prompted placeholders", prompted)
+	}
+
+	if _, err := templatepkg.NewSession("{@random:0..9223372036854775807}"); err == nil {
+		t.Fatalf("expected a random range wider than an int to be rejected")
+	}
+	if _, err := templatepkg.NewSession("{@random:-9223372036854775806..0}"); err != nil {
+		t.Fatalf("expected the widest valid random range to parse, got %v", err)
+	}
+}
+
+func TestRunIncludes(t *testing.T) {
+	withTerminal(t, false)
+	withRunPrompter(t, []string{"alice", "5", "またね"})
+
+	dir := t.TempDir()
+	library := t.TempDir()
+	t.Setenv(templatepkg.LibraryEnv, library)
+
+	files := map[string]templatepkg.Document{
+		filepath.Join(dir, "header.yaml"): {Template: "【{名前}さんへの質問】\n"},
+		filepath.Join(library, "footer.yaml"): {
+			Template: "{一言}\n#{名前}",
+			Fields:   map[string]templatepkg.Field{"一言": {Default: "よろしく"}},
+		},
+		filepath.Join(dir, "tpl.yaml"): {Template: "{>header}\n好感度: {好感度}\n{>footer}"},
+	}
+	for path, doc := range files {
+		if err := templatepkg.WriteFile(path, doc); err != nil {
+			t.Fatalf("write template: %v", err)
+		}
+	}
+
+	cmd := NewRootCmd()
+	outBuf := &bytes.Buffer{}
+	cmd.SetOut(outBuf)
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetArgs([]string{"run", "--in", filepath.Join(dir, "tpl.yaml")})
+
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("execute: %v", err)
+	}
+
+	expected := "【aliceさんへの質問】\n好感度: 5\nまたね\n#alice"
+	if outBuf.String() != expected {
+		t.Fatalf("unexpected output: want %q, got %q", expected, outBuf.String())
+	}
+
+	doc, err := templatepkg.LoadFile(filepath.Join(dir, "tpl.yaml"))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if doc.Fields["一言"].Default != "よろしく" {
+		t.Fatalf("expected fields of the partial to be merged, got %+v", doc.Fields)
+	}
+
+	if err := templatepkg.WriteFile(filepath.Join(dir, "header.yaml"), templatepkg.Document{Template: "{>tpl}"}); err != nil {
+		t.Fatalf("write template: %v", err)
+	}
+	_, err = templatepkg.LoadFile(filepath.Join(dir, "tpl.yaml"))
+	if err == nil || !strings.Contains(err.Error(), "include cycle") {
+		t.Fatalf("expected include cycle error, got %v", err)
+	}
+
+	if err := templatepkg.WriteFile(filepath.Join(dir, "header.yaml"), templatepkg.Document{Template: "{>missing}"}); err != nil {
+		t.Fatalf("write template: %v", err)
+	}
+	_, err = templatepkg.LoadFile(filepath.Join(dir, "tpl.yaml"))
+	if err == nil || !strings.Contains(err.Error(), `template "missing" not found`) {
+		t.Fatalf("expected missing partial error, got %v", err)
+	}
+
+	header := filepath.Join(dir, "header.yaml")
+	if err := templatepkg.WriteFile(header, templatepkg.Document{Template: "Q.\n{名前 | uper}"}); err != nil {
+		t.Fatalf("write template: %v", err)
+	}
+	_, err = templatepkg.LoadFile(filepath.Join(dir, "tpl.yaml"))
+	var parseErr *templatepkg.ParseError
+	if !errors.As(err, &parseErr) || !strings.HasPrefix(err.Error(), header+`: 2:7: unknown filter "uper"`) {
+		t.Fatalf("expected the error to point into the partial, got %v", err)
+	}
+	if parseErr.Line != "{名前 | uper}" {
+		t.Fatalf("expected the excerpt to come from the partial, got %q", parseErr.Line)
+	}
+}
+
+func TestRunExtends(t *testing.T) {
+	withTerminal(t, false)
+	withRunPrompter(t, []string{"alice", "歌"})
+
+	dir := t.TempDir()
+	files := map[string]templatepkg.Document{
+		filepath.Join(dir, "base.yaml"): {
+			Title:    "好きなところ",
+			Template: "{名前}さんの好きなところ\n{$好き}\n・{}\n{/}\n{$締め}よろしく！{/}",
+			Fields:   map[string]templatepkg.Field{"名前": {Default: "名無し"}},
+		},
+		filepath.Join(dir, "fandom.yaml"): {
+			Title:   "推しの好きなところ",
+			Extends: "base",
+			Blocks:  map[string]string{"好き": "推しポイント: {推し}\n"},
+		},
+		filepath.Join(dir, "child.yaml"): {
+			Extends: "fandom.yaml",
+			Blocks:  map[string]string{"締め": "{>footer}"},
+		},
+		filepath.Join(dir, "footer.yaml"): {Template: "またね\n"},
+	}
+	for path, doc := range files {
+		if err := templatepkg.WriteFile(path, doc); err != nil {
+			t.Fatalf("write template: %v", err)
+		}
+	}
+
+	cmd := NewRootCmd()
+	outBuf := &bytes.Buffer{}
+	cmd.SetOut(outBuf)
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetArgs([]string{"run", "--in", filepath.Join(dir, "child.yaml")})
+
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("execute: %v", err)
+	}
+
+	expected := "aliceさんの好きなところ\n推しポイント: 歌\nまたね"
 	if outBuf.String() != expected {
 		t.Fatalf("unexpected output: want %q, got %q", expected, outBuf.String())
 	}
+
+	cmd = NewRootCmd()
+	outBuf = &bytes.Buffer{}
+	cmd.SetOut(outBuf)
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetArgs([]string{"show", "--flatten", "--in", filepath.Join(dir, "child.yaml")})
+
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("execute show: %v", err)
+	}
+	for _, want := range []string{"title: 推しの好きなところ", "推しポイント: {推し}", "またね", "default: 名無し"} {
+		if !strings.Contains(outBuf.String(), want) {
+			t.Fatalf("expected flattened output to contain %q, got:\n%s", want, outBuf.String())
+		}
+	}
+	if strings.Contains(outBuf.String(), "extends") || strings.Contains(outBuf.String(), "{$") || strings.Contains(outBuf.String(), "{>") {
+		t.Fatalf("expected extends, blocks and includes to be resolved, got:\n%s", outBuf.String())
+	}
+
+	cmd = NewRootCmd()
+	outBuf = &bytes.Buffer{}
+	cmd.SetOut(outBuf)
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetArgs([]string{"show", "--in", filepath.Join(dir, "child.yaml")})
+
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("execute show: %v", err)
+	}
+	if expected := "title: 推しの好きなところ\n1:1\ttext\t名前\n2:9\ttext\t推し\n"; outBuf.String() != expected {
+		t.Fatalf("unexpected description: want %q, got %q", expected, outBuf.String())
+	}
+
+	if err := templatepkg.WriteFile(filepath.Join(dir, "optional.yaml"), templatepkg.Document{
+		Extends:  "fandom",
+		Optional: true,
+	}); err != nil {
+		t.Fatalf("write template: %v", err)
+	}
+	cmd = NewRootCmd()
+	outBuf = &bytes.Buffer{}
+	cmd.SetOut(outBuf)
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetArgs([]string{"run", "--in", filepath.Join(dir, "optional.yaml"), "--set", "名前=bob", "--set", "推し="})
+
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("execute optional: %v", err)
+	}
+	if expected := "bobさんの好きなところ\nよろしく！"; outBuf.String() != expected {
+		t.Fatalf("expected optional to be inherited: want %q, got %q", expected, outBuf.String())
+	}
+
+	if err := templatepkg.WriteFile(filepath.Join(dir, "child.yaml"), templatepkg.Document{
+		Extends: "fandom",
+		Blocks:  map[string]string{"未定義": "x"},
+	}); err != nil {
+		t.Fatalf("write template: %v", err)
+	}
+	_, err := templatepkg.LoadFile(filepath.Join(dir, "child.yaml"))
+	if err == nil || !strings.Contains(err.Error(), `block "未定義" is not defined`) {
+		t.Fatalf("expected unknown block error, got %v", err)
+	}
+}
+
+func TestRunBundles(t *testing.T) {
+	withTerminal(t, false)
+
+	dir := t.TempDir()
+	stream := filepath.Join(dir, "stream.yaml")
+	list := filepath.Join(dir, "list.yaml")
+	files := map[string]string{
+		stream: "title: 好きなところ\ntemplate: \"{名前}の好きなところ: {好きなところ}\"\n---\ntitle: 質問箱\ntemplate: \"Q. {質問}\"\n",
+		list:   "version: 2\ntemplates:\n  - title: あいさつ\n    template: \"おはよう {名前}\"\n  - title: 好きなところ\n    template: \"{名前}の好きなところ\"\n",
+	}
+	for path, data := range files {
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatalf("write bundle: %v", err)
+		}
+	}
+
+	tests := []struct {
+		args      []string
+		responses []string
+		expected  string
+	}{
+		{[]string{"--in", stream, "--name", "好きなところ"}, []string{"alice", "声"}, "aliceの好きなところ: 声"},
+		{[]string{"--in", stream}, []string{"質問箱", "好きな色は？"}, "Q. 好きな色は？"},
+		{[]string{"--in", list, "--name", "あいさつ", "--set", "名前=bob"}, nil, "おはよう bob"},
+	}
+
+	for _, tt := range tests {
+		withRunPrompter(t, tt.responses)
+
+		cmd := NewRootCmd()
+		outBuf := &bytes.Buffer{}
+		cmd.SetOut(outBuf)
+		cmd.SetErr(&bytes.Buffer{})
+		cmd.SetArgs(append([]string{"run"}, tt.args...))
+
+		if err := cmd.Execute(); err != nil {
+			t.Fatalf("execute %v: %v", tt.args, err)
+		}
+		if outBuf.String() != tt.expected {
+			t.Fatalf("unexpected output for %v: want %q, got %q", tt.args, tt.expected, outBuf.String())
+		}
+	}
+
+	cmd := NewRootCmd()
+	cmd.SetOut(&bytes.Buffer{})
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetArgs([]string{"run", "--in", list, "--set", "名前=bob"})
+	if err := cmd.Execute(); err == nil || !strings.Contains(err.Error(), "choose one with --name") {
+		t.Fatalf("expected a non-interactive bundle to require --name, got %v", err)
+	}
+
+	cmd = NewRootCmd()
+	cmd.SetOut(&bytes.Buffer{})
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetArgs([]string{"run", "--in", list, "--name", "未知", "--set", "名前=bob"})
+	if err := cmd.Execute(); !errors.Is(err, templatepkg.ErrTemplateNotFound) || !strings.Contains(err.Error(), "あいさつ, 好きなところ") {
+		t.Fatalf("expected the available titles to be listed, got %v", err)
+	}
+
+	if _, err := templatepkg.LoadFile(list); !errors.Is(err, templatepkg.ErrBundle) {
+		t.Fatalf("expected LoadFile to reject a bundle, got %v", err)
+	}
+}
+
+func TestRunSyntaxErrors(t *testing.T) {
+	withTerminal(t, false)
+
+	tests := []struct {
+		template string
+		message  string
+		excerpt  string
+	}{
+		{"A\n好感度: {", "2:6: { is not closed with } on the same line (write \\{ for a literal brace)", "好感度: {\n        ^"},
+		{"好き}", "1:3: unmatched } (write \\} for a literal brace)", "好き}\n    ^"},
+		{"{a{b}}", "1:3: { cannot appear inside a placeholder", "{a{b}}\n  ^"},
+		{"x {名前: a|}", "1:3: invalid placeholder {名前: a|}", "x {名前: a|}\n  ^"},
+		{"{?a b}{/}", "1:1: invalid section name in {?a b}", "{?a b}{/}\n^"},
+	}
+
+	for _, tt := range tests {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "tpl.yaml")
+		if err := templatepkg.WriteFile(path, templatepkg.Document{Template: tt.template}); err != nil {
+			t.Fatalf("write template: %v", err)
+		}
+
+		cmd := NewRootCmd()
+		cmd.SetOut(&bytes.Buffer{})
+		cmd.SetErr(&bytes.Buffer{})
+		cmd.SetArgs([]string{"run", "--in", path})
+
+		err := cmd.Execute()
+		var parseErr *templatepkg.ParseError
+		if !errors.As(err, &parseErr) {
+			t.Fatalf("%q: expected a parse error, got %v", tt.template, err)
+		}
+		if err.Error() != tt.message {
+			t.Fatalf("%q: unexpected message: want %q, got %q", tt.template, tt.message, err.Error())
+		}
+		if parseErr.Excerpt() != tt.excerpt {
+			t.Fatalf("%q: unexpected excerpt: want %q, got %q", tt.template, tt.excerpt, parseErr.Excerpt())
+		}
+	}
+}
+
+func TestRunExpressions(t *testing.T) {
+	withTerminal(t, false)
+	withRunPrompter(t, []string{"9", ""})
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tpl.yaml")
+	doc := templatepkg.Document{
+		Template: "好感度: {好感度: gauge 0..10 ■□}\n点数: {= 好感度 * 10}点\n" +
+			"ランク: {= 好感度 >= 8 ? \"大好き\" : 好感度 >= 5 ? \"好き\" : \"ふつう\"}\n" +
+			"{= 名前 || \"名無し\" | quote}さん{= (好感度 - 1) / 2 + \"!\"}",
+	}
+	if err := templatepkg.WriteFile(path, doc); err != nil {
+		t.Fatalf("write template: %v", err)
+	}
+
+	run := func(args ...string) (string, error) {
+		cmd := NewRootCmd()
+		outBuf := &bytes.Buffer{}
+		cmd.SetOut(outBuf)
+		cmd.SetErr(&bytes.Buffer{})
+		cmd.SetArgs(append([]string{"run", "--in", path}, args...))
+		err := cmd.Execute()
+		return outBuf.String(), err
+	}
+
+	expected := "好感度: ■■■■■■■■■□\n点数: 90点\nランク: 大好き\n「名無し」さん4!"
+	if got, err := run(); err != nil || got != expected {
+		t.Fatalf("unexpected output: want %q, got %q (%v)", expected, got, err)
+	}
+
+	expected = "好感度: ■■■■■■□□□□\n点数: 60点\nランク: 好き\n「alice」さん2.5!"
+	if got, err := run("--set", "好感度=6", "--set", "名前=alice"); err != nil || got != expected {
+		t.Fatalf("unexpected output: want %q, got %q (%v)", expected, got, err)
+	}
+
+	_, err := templatepkg.NewSession("A\n点数: {= 好感度 *}")
+	if err == nil || err.Error() != "2:13: expression: unexpected end of expression" {
+		t.Fatalf("expected a positioned expression error, got %v", err)
+	}
+
+	session, err := templatepkg.NewSession("{= 10 / x}")
+	if err != nil {
+		t.Fatalf("new session: %v", err)
+	}
+	_, err = session.Fill([]templatepkg.Value{templatepkg.TextValue("0"), {}})
+	if err == nil || !strings.Contains(err.Error(), "division by zero") {
+		t.Fatalf("expected division by zero error, got %v", err)
+	}
+	session, err = templatepkg.NewSession(`{= "a}b" + '{c}'}`)
+	if err != nil {
+		t.Fatalf("expected braces in string literals to be allowed, got %v", err)
+	}
+	if got, err := session.Fill([]templatepkg.Value{{}}); err != nil || got != "a}b{c}" {
+		t.Fatalf("unexpected output: got %q (%v)", got, err)
+	}
+}
+
+func TestRunUnclosedSection(t *testing.T) {
+	withTerminal(t, false)
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tpl.yaml")
+	if err := templatepkg.WriteFile(path, templatepkg.Document{Template: "A\n{?x}B"}); err != nil {
+		t.Fatalf("write template: %v", err)
+	}
+
+	cmd := NewRootCmd()
+	cmd.SetOut(&bytes.Buffer{})
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetArgs([]string{"run", "--in", path})
+
+	err := cmd.Execute()
+	if err == nil || !strings.Contains(err.Error(), "2:1") {
+		t.Fatalf("expected unclosed section error with position, got %v", err)
+	}
 }
 
 func TestRunNoEmpty(t *testing.T) {
@@ -171,6 +1587,12 @@ func withRunPrompter(t *testing.T, responses []string) {
 	})
 }
 
+func withRunClock(t *testing.T, now time.Time) {
+	prev := runClock
+	runClock = func() time.Time { return now }
+	t.Cleanup(func() { runClock = prev })
+}
+
 func withTerminal(t *testing.T, value bool) {
 	prev := isTerminalFunc
 	isTerminalFunc = func(io.Writer) bool { return value }
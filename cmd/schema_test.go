@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func TestSchema(t *testing.T) {
+	cmd := NewRootCmd()
+	outBuf := &bytes.Buffer{}
+	cmd.SetOut(outBuf)
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetArgs([]string{"schema"})
+
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("execute: %v", err)
+	}
+
+	type property struct {
+		Type  any      `json:"type"`
+		Ref   string   `json:"$ref"`
+		Enum  []string `json:"enum"`
+		Items struct {
+			Type any    `json:"type"`
+			Ref  string `json:"$ref"`
+		} `json:"items"`
+	}
+	type object struct {
+		Properties           map[string]property `json:"properties"`
+		AdditionalProperties bool                `json:"additionalProperties"`
+	}
+	var schema struct {
+		Schema string `json:"$schema"`
+		object
+		Defs map[string]object `json:"$defs"`
+	}
+	if err := json.Unmarshal(outBuf.Bytes(), &schema); err != nil {
+		t.Fatalf("decode schema: %v\n%s", err, outBuf.String())
+	}
+
+	if schema.Schema == "" || schema.AdditionalProperties {
+		t.Fatalf("expected a closed schema with a dialect, got %+v", schema.object)
+	}
+	for _, key := range []string{"version", "title", "template", "fields", "markers", "optional", "extends", "blocks"} {
+		if _, ok := schema.Properties[key]; !ok {
+			t.Fatalf("expected the root to have %q, got %v", key, schema.Properties)
+		}
+	}
+	if templates := schema.Properties["templates"]; templates.Type != "array" || templates.Items.Ref != "#/$defs/Document" {
+		t.Fatalf("expected templates to be a list of documents, got %+v", templates)
+	}
+
+	field, ok := schema.Defs["Field"]
+	if !ok {
+		t.Fatalf("expected a Field definition, got %v", schema.Defs)
+	}
+	if field.Properties["required"].Type != "boolean" || field.Properties["min"].Type != "integer" {
+		t.Fatalf("unexpected field properties: %+v", field.Properties)
+	}
+	scalar := []any{"string", "number", "boolean"}
+	if !reflect.DeepEqual(field.Properties["default"].Type, scalar) || !reflect.DeepEqual(field.Properties["choices"].Items.Type, scalar) {
+		t.Fatalf("expected default and choices to accept any scalar, got %+v", field.Properties)
+	}
+	kinds := field.Properties["type"].Enum
+	if !slices.Contains(kinds, "stars") || slices.Contains(kinds, "computed") {
+		t.Fatalf("unexpected kinds: %v", kinds)
+	}
+}
@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+
+	templatepkg "github.com/AkatukiSora/twitter-dore/internal/template"
+)
+
+func newShowCmd() *cobra.Command {
+	var (
+		inputPath string
+		name      string
+		flatten   bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "show",
+		Short: "Describe a template and the placeholders it asks for",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if inputPath == "" {
+				return errors.New("--in is required")
+			}
+
+			doc, _, err := loadTemplate(inputPath, name, nil)
+			if err != nil {
+				return err
+			}
+
+			if flatten {
+				data, err := yaml.Marshal(doc)
+				if err != nil {
+					return fmt.Errorf("failed to marshal template YAML: %w", err)
+				}
+				_, err = cmd.OutOrStdout().Write(data)
+				return err
+			}
+
+			session, err := doc.NewSession()
+			if err != nil {
+				return err
+			}
+			_, err = fmt.Fprint(cmd.OutOrStdout(), describe(doc, session))
+			return err
+		},
+	}
+
+	cmd.Flags().StringVar(&inputPath, "in", "", "Path to template YAML")
+	cmd.Flags().StringVar(&name, "name", "", "Title of the template to show when the file is a bundle")
+	cmd.Flags().BoolVar(&flatten, "flatten", false, "Print the template with includes and extends resolved")
+
+	_ = cmd.MarkFlagRequired("in")
+
+	return cmd
+}
+
+// describe summarises a document: its title and description, followed by one
+// line per placeholder with its kind and first position.
+func describe(doc templatepkg.Document, session *templatepkg.Session) string {
+	var builder strings.Builder
+	if doc.Title != "" {
+		fmt.Fprintf(&builder, "title: %s\n", doc.Title)
+	}
+	if doc.Description != "" {
+		fmt.Fprintf(&builder, "description: %s\n", doc.Description)
+	}
+	for _, placeholder := range session.Placeholders() {
+		fmt.Fprintf(&builder, "%s\t%s\t%s\n", placeholder.Pos, placeholder.Kind, placeholder.Label)
+	}
+	return builder.String()
+}
@@ -0,0 +1,89 @@
+// Package state persists the small amount of data twitter-dore keeps between
+// runs, such as the counters behind "{@counter}".
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// PathEnv overrides the location of the state file.
+const PathEnv = "TWITTER_DORE_STATE"
+
+// DefaultPath returns the state file location: $TWITTER_DORE_STATE when set,
+// otherwise state.json in the user's configuration directory.
+func DefaultPath() (string, error) {
+	if path := os.Getenv(PathEnv); path != "" {
+		return path, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to locate state directory: %w", err)
+	}
+	return filepath.Join(dir, "twitter-dore", "state.json"), nil
+}
+
+// Store reads and writes a JSON state file.
+type Store struct {
+	path string
+}
+
+// New returns a store backed by the file at path, which is created on the
+// first write.
+func New(path string) *Store {
+	return &Store{path: path}
+}
+
+type document struct {
+	Counters map[string]int `json:"counters"`
+}
+
+// NextCounter increments the named counter, saves it and returns the new
+// value. A counter that was never used starts at 1.
+func (s *Store) NextCounter(key string) (int, error) {
+	doc, err := s.load()
+	if err != nil {
+		return 0, err
+	}
+	doc.Counters[key]++
+	if err := s.save(doc); err != nil {
+		return 0, err
+	}
+	return doc.Counters[key], nil
+}
+
+func (s *Store) load() (document, error) {
+	doc := document{Counters: make(map[string]int)}
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return doc, nil
+	}
+	if err != nil {
+		return document{}, fmt.Errorf("failed to read state file: %w", err)
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return document{}, fmt.Errorf("failed to decode state file %s: %w", s.path, err)
+	}
+	if doc.Counters == nil {
+		doc.Counters = make(map[string]int)
+	}
+	return doc, nil
+}
+
+func (s *Store) save(doc document) error {
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode state: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+	if err := os.WriteFile(s.path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package template
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Attributes are the quoted key="value" settings that may follow a
+// placeholder's name, default and spec, as in
+// `{好感度: gauge label="好感度" hint="0〜10で"}`.
+type Attributes struct {
+	// Label replaces the inferred prompt label.
+	Label string
+	// Hint is a longer help text shown under the prompt context.
+	Hint string
+	// Example is a sample answer shown alongside the hint.
+	Example string
+}
+
+// attributePattern matches one attribute. Only the reserved keys are
+// recognised; values cannot contain double quotes.
+var attributePattern = regexp.MustCompile(`(?:^|\s+)(label|hint|example)="([^"]*)"`)
+
+// cutAttributes removes the attributes from a placeholder body and returns the
+// rest. The bool result is false when a key is given twice.
+func cutAttributes(body string) (string, Attributes, string, bool) {
+	matches := attributePattern.FindAllStringSubmatchIndex(body, -1)
+	if len(matches) == 0 {
+		return body, Attributes{}, "", true
+	}
+
+	var (
+		attrs Attributes
+		rest  strings.Builder
+		seen  = make(map[string]bool, len(matches))
+		last  int
+	)
+	for _, match := range matches {
+		key, value := body[match[2]:match[3]], body[match[4]:match[5]]
+		if seen[key] {
+			return "", Attributes{}, key, false
+		}
+		seen[key] = true
+
+		switch key {
+		case "label":
+			attrs.Label = value
+		case "hint":
+			attrs.Hint = value
+		case "example":
+			attrs.Example = value
+		}
+		rest.WriteString(body[last:match[0]])
+		last = match[1]
+	}
+	rest.WriteString(body[last:])
+
+	return strings.TrimSpace(rest.String()), attrs, "", true
+}
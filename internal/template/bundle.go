@@ -0,0 +1,153 @@
+package template
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ErrBundle indicates that a file holds several templates where one was
+// expected.
+var ErrBundle = errors.New("file is a bundle of templates")
+
+// ErrTemplateNotFound indicates that no template in a bundle has the
+// requested title.
+var ErrTemplateNotFound = errors.New("template not found")
+
+// bundleFile is one YAML document of a template file: a single template, or a
+// "templates" list sharing the document's version.
+type bundleFile struct {
+	Document  `yaml:",inline"`
+	Templates []Document `yaml:"templates,omitempty"`
+}
+
+// LoadBundle reads every template in the file, resolving each like LoadFile.
+// Templates are bundled either as a YAML stream separated by "---" or as a
+// top-level "templates" list.
+func LoadBundle(path string) ([]Document, error) {
+	docs, err := (&loader{}).load(path)
+	if err != nil {
+		return nil, err
+	}
+	for i := range docs {
+		if docs[i].Template, err = stripBlocks(docs[i].Template); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return docs, nil
+}
+
+// Find returns the template with the given title.
+func Find(docs []Document, title string) (Document, error) {
+	for _, doc := range docs {
+		if doc.Title == title {
+			return doc, nil
+		}
+	}
+	return Document{}, fmt.Errorf("%w: %q (available: %s)", ErrTemplateNotFound, title, strings.Join(Titles(docs), ", "))
+}
+
+// Titles returns the title of each template, or "#N" for untitled ones.
+func Titles(docs []Document) []string {
+	titles := make([]string, len(docs))
+	for i, doc := range docs {
+		titles[i] = doc.Title
+		if titles[i] == "" {
+			titles[i] = fmt.Sprintf("#%d", i+1)
+		}
+	}
+	return titles
+}
+
+// AppendFile adds doc to the bundle at path. A file with a "templates" list
+// gets a new list entry and any other file a new "---" document, so the
+// existing content and its comments are kept. A missing file is created.
+func AppendFile(path string, doc Document) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) || (err == nil && strings.TrimSpace(string(data)) == "") {
+		return WriteFile(path, doc)
+	}
+	if err != nil {
+		return err
+	}
+
+	existing, err := decodeFile(path)
+	if err != nil {
+		return err
+	}
+	for _, other := range existing {
+		if doc.Title != "" && other.Title == doc.Title {
+			return fmt.Errorf("%s already has a template titled %q", path, doc.Title)
+		}
+	}
+	if doc.Version == 0 {
+		doc.Version = CurrentVersion
+	}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return fmt.Errorf("failed to decode template YAML: %w", err)
+	}
+	if len(existing) > 1 || root.Kind != yaml.DocumentNode || mappingValue(root.Content[0], "templates") == nil {
+		encoded, err := yaml.Marshal(doc)
+		if err != nil {
+			return fmt.Errorf("failed to marshal template YAML: %w", err)
+		}
+		if !strings.HasSuffix(string(data), "\n") {
+			data = append(data, '\n')
+		}
+		data = append(append(data, "---\n"...), encoded...)
+		return os.WriteFile(path, data, 0o644)
+	}
+
+	var entry yaml.Node
+	if err := entry.Encode(doc); err != nil {
+		return fmt.Errorf("failed to marshal template YAML: %w", err)
+	}
+	templates := mappingValue(root.Content[0], "templates")
+	templates.Content = append(templates.Content, &entry)
+
+	encoded, err := encodeNodes(sourceIndent(data), &root)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, encoded, 0o644)
+}
+
+// defaultIndent is the indentation of yaml.Marshal, which WriteFile uses.
+const defaultIndent = 4
+
+// sourceIndent returns the indentation of YAML source: the narrowest
+// indentation of any line, or defaultIndent when no line is indented.
+func sourceIndent(data []byte) int {
+	indent := 0
+	for _, line := range strings.Split(string(data), "\n") {
+		content := strings.TrimLeft(line, " ")
+		if width := len(line) - len(content); width > 0 && content != "" && (indent == 0 || width < indent) {
+			indent = width
+		}
+	}
+	if indent == 0 {
+		return defaultIndent
+	}
+	return indent
+}
+
+// encodeNodes writes YAML node trees as a stream with the given indentation.
+func encodeNodes(indent int, roots ...*yaml.Node) ([]byte, error) {
+	var builder strings.Builder
+	encoder := yaml.NewEncoder(&builder)
+	encoder.SetIndent(indent)
+	for _, root := range roots {
+		if err := encoder.Encode(root); err != nil {
+			return nil, fmt.Errorf("failed to marshal template YAML: %w", err)
+		}
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, fmt.Errorf("failed to marshal template YAML: %w", err)
+	}
+	return []byte(builder.String()), nil
+}
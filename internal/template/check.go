@@ -0,0 +1,263 @@
+package template
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Severity ranks a Problem. Errors make Validate fail; warnings are only
+// reported by tools such as the lint command.
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+)
+
+// Problem is a mistake in a document that parses but is probably not what
+// the author meant.
+type Problem struct {
+	// Pos is the placeholder the problem concerns in the template body, or
+	// the line in the file for CheckKeys. It is zero for problems with a
+	// field declaration.
+	Pos      Pos
+	Severity Severity
+	Message  string
+}
+
+func (p Problem) Error() string {
+	if p.Pos.Line == 0 {
+		return p.Message
+	}
+	return fmt.Sprintf("%d:%d: %s", p.Pos.Line, p.Pos.Column, p.Message)
+}
+
+// Check parses the template and cross-checks its placeholders against the
+// fields section. Syntax errors are returned as the error.
+func (d Document) Check() ([]Problem, error) {
+	session, err := d.NewSession()
+	if err != nil {
+		return nil, err
+	}
+	return d.check(session), nil
+}
+
+// check reports declarations no placeholder uses and, when the fields section
+// is used at all, named placeholders it leaves out. It also reports
+// placeholders sharing a label, which would be indistinguishable in prompts
+// and answer files, where two inferred labels only warrant a warning, and
+// anonymous placeholders left with a "fieldN" label.
+func (d Document) check(session *Session) []Problem {
+	problems := make([]Problem, 0)
+	placeholders := session.Placeholders()
+
+	for _, placeholder := range placeholders {
+		if placeholder.unlabeled && !placeholder.labeled {
+			problems = append(problems, Problem{
+				Pos:      placeholder.Pos,
+				Severity: SeverityWarning,
+				Message:  fmt.Sprintf("placeholder has no label and is asked as %q (write text before it or add a label attribute)", placeholder.Label),
+			})
+		}
+	}
+
+	used := make(map[string]bool, len(placeholders))
+	for _, placeholder := range placeholders {
+		if placeholder.Name == "" || placeholder.Kind.IsComputed() {
+			continue
+		}
+		used[placeholder.Name] = true
+		if _, ok := d.Fields[placeholder.Name]; !ok && len(d.Fields) > 0 {
+			problems = append(problems, Problem{
+				Pos:      placeholder.Pos,
+				Severity: SeverityWarning,
+				Message:  fmt.Sprintf("placeholder %q is not declared in fields", placeholder.Name),
+			})
+		}
+	}
+
+	names := make([]string, 0, len(d.Fields))
+	for name := range d.Fields {
+		if !used[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		problems = append(problems, Problem{
+			Severity: SeverityError,
+			Message:  fmt.Sprintf("field %q is declared but not used in the template", name),
+		})
+	}
+
+	byLabel := make(map[string]Placeholder, len(placeholders))
+	for _, placeholder := range placeholders {
+		if placeholder.Kind.IsComputed() {
+			continue
+		}
+		first, ok := byLabel[placeholder.Label]
+		if !ok {
+			byLabel[placeholder.Label] = placeholder
+			continue
+		}
+		severity := SeverityError
+		if !first.explicitLabel() && !placeholder.explicitLabel() {
+			severity = SeverityWarning
+		}
+		problems = append(problems, Problem{
+			Pos:      placeholder.Pos,
+			Severity: severity,
+			Message:  fmt.Sprintf("label %q is also used by the placeholder at %d:%d", placeholder.Label, first.Pos.Line, first.Pos.Column),
+		})
+	}
+
+	return problems
+}
+
+// explicitLabel reports whether the label was chosen by the author, as a name,
+// a label attribute or a field, rather than inferred from the text before it.
+func (p Placeholder) explicitLabel() bool {
+	return p.Name != "" || p.labeled
+}
+
+// CheckKeys reports keys in the YAML source that the document format does not
+// know, which LoadFile silently ignores. Positions are lines of the source.
+func CheckKeys(data []byte) ([]Problem, error) {
+	problems := make([]Problem, 0)
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	for {
+		var file bundleFile
+		err := decoder.Decode(&file)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		var typeErr *yaml.TypeError
+		if !errors.As(err, &typeErr) {
+			if err != nil {
+				return nil, fmt.Errorf("failed to decode template YAML: %w", err)
+			}
+			continue
+		}
+		for _, message := range typeErr.Errors {
+			problems = append(problems, keyProblem(message))
+		}
+	}
+	return problems, nil
+}
+
+// CheckVersions reports documents in the YAML source that have no version.
+// They are read by the rules of version 1, under which a body in the original
+// syntax has braces that form no placeholder printed as text rather than
+// reported. Positions are lines of the source.
+func CheckVersions(data []byte) ([]Problem, error) {
+	problems := make([]Problem, 0)
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	for {
+		var root yaml.Node
+		if err := decoder.Decode(&root); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to decode template YAML: %w", err)
+		}
+		if len(root.Content) == 0 || root.Content[0].Kind != yaml.MappingNode {
+			continue
+		}
+
+		mapping := root.Content[0]
+		var file bundleFile
+		if err := root.Decode(&file); err != nil {
+			return nil, fmt.Errorf("failed to decode template YAML: %w", err)
+		}
+		if file.Version == 0 && len(file.Templates) == 0 {
+			problems = append(problems, versionProblem(mapping, file.Document))
+		}
+		if templates := mappingValue(mapping, "templates"); templates != nil && templates.Kind == yaml.SequenceNode {
+			for i, item := range templates.Content {
+				if file.Version == 0 && file.Templates[i].Version == 0 {
+					problems = append(problems, versionProblem(item, file.Templates[i]))
+				}
+			}
+		}
+	}
+	return problems, nil
+}
+
+func versionProblem(mapping *yaml.Node, doc Document) Problem {
+	message := fmt.Sprintf("no version; add version: %d", CurrentVersion)
+	lenient := escapeLenientText(doc.Template) != doc.Template
+	for _, block := range doc.Blocks {
+		lenient = lenient || escapeLenientText(block) != block
+	}
+	if lenient {
+		message = fmt.Sprintf("no version, so braces and backslashes that form no placeholder are printed as text; "+
+			"run twitter-dore migrate or add version: %d to have them reported", CurrentVersion)
+	}
+	return Problem{
+		Pos:      Pos{Line: mapping.Line, Column: mapping.Column},
+		Severity: SeverityWarning,
+		Message:  message,
+	}
+}
+
+// unknownKeyPattern matches the message yaml.v3 gives for an unknown key.
+var unknownKeyPattern = regexp.MustCompile(`^line (\d+): field (.+) not found in type \S+$`)
+
+func keyProblem(message string) Problem {
+	match := unknownKeyPattern.FindStringSubmatch(message)
+	if match == nil {
+		return Problem{Severity: SeverityError, Message: message}
+	}
+	line, _ := strconv.Atoi(match[1])
+	return Problem{
+		Pos:      Pos{Line: line, Column: 1},
+		Severity: SeverityError,
+		Message:  fmt.Sprintf("unknown key %q", match[2]),
+	}
+}
+
+// MinimalValues returns the shortest answers a user could give: nothing for
+// placeholders that may be left empty, and otherwise the default, the example
+// or the smallest value the spec accepts. Filling them shows how long the
+// template is before anything is written into it.
+func (s *Session) MinimalValues() []Value {
+	values := make([]Value, len(s.placeholders))
+	for i, placeholder := range s.placeholders {
+		if !placeholder.Required || placeholder.Kind.IsComputed() {
+			continue
+		}
+		values[i] = placeholder.minimalValue()
+	}
+	return values
+}
+
+func (p Placeholder) minimalValue() Value {
+	switch {
+	case p.Default != "":
+		return p.DefaultValue()
+	case p.Example != "":
+		return p.ParseValue(p.Example)
+	case len(p.Choices) > 0:
+		shortest := p.Choices[0]
+		for _, choice := range p.Choices[1:] {
+			if len([]rune(choice)) < len([]rune(shortest)) {
+				shortest = choice
+			}
+		}
+		return p.ParseValue(shortest)
+	case p.Kind.IsScale():
+		return TextValue(strconv.Itoa(p.Min))
+	case p.Kind == KindInt || p.Kind == KindNumber:
+		return TextValue("0")
+	default:
+		return p.ParseValue(strings.Repeat("x", max(p.MinLength, 1)))
+	}
+}
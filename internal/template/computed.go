@@ -0,0 +1,181 @@
+package template
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Environment supplies the inputs of computed placeholders. A Session starts
+// with an environment using the clock, a random source and an in-memory
+// counter; callers replace it to make counters persistent.
+type Environment struct {
+	Now    func() time.Time
+	Random func(minValue, maxValue int) int
+	// Counter returns the next value of the named counter. The name is empty
+	// for a plain "{@counter}".
+	Counter func(name string) (int, error)
+}
+
+func defaultEnvironment() Environment {
+	counters := make(map[string]int)
+	return Environment{
+		Now: time.Now,
+		Random: func(minValue, maxValue int) int {
+			return minValue + rand.Intn(maxValue-minValue+1)
+		},
+		Counter: func(name string) (int, error) {
+			counters[name]++
+			return counters[name], nil
+		},
+	}
+}
+
+// builtin describes a computed placeholder written "{@name}" or "{@name:args}".
+type builtin struct {
+	// check validates the arguments at parse time.
+	check func(args string) bool
+	// compute produces the value when the template is filled.
+	compute func(env Environment, args string) (string, error)
+}
+
+const (
+	defaultDateLayout = "2006/01/02"
+	defaultTimeLayout = "15:04"
+)
+
+var (
+	weekdaysJa = []string{"日", "月", "火", "水", "木", "金", "土"}
+
+	builtins = map[string]builtin{
+		"today": {
+			check:   anyArgs,
+			compute: formatNow(defaultDateLayout),
+		},
+		"now": {
+			check:   anyArgs,
+			compute: formatNow(defaultDateLayout + " " + defaultTimeLayout),
+		},
+		"time": {
+			check:   anyArgs,
+			compute: formatNow(defaultTimeLayout),
+		},
+		"weekday": {
+			check: func(args string) bool { return args == "" || args == "ja" || args == "en" },
+			compute: func(env Environment, args string) (string, error) {
+				weekday := env.Now().Weekday()
+				if args == "en" {
+					return weekday.String()[:3], nil
+				}
+				return weekdaysJa[weekday], nil
+			},
+		},
+		"counter": {
+			check: func(args string) bool { return args == "" || isName(args) },
+			compute: func(env Environment, args string) (string, error) {
+				value, err := env.Counter(args)
+				if err != nil {
+					return "", fmt.Errorf("counter: %w", err)
+				}
+				return strconv.Itoa(value), nil
+			},
+		},
+		"random": {
+			check: func(args string) bool {
+				minValue, maxValue, ok := parseRange(args)
+				// The span maxValue-minValue+1 must fit in an int for rand.Intn.
+				span := maxValue - minValue
+				return ok && minValue <= maxValue && span >= 0 && span < math.MaxInt
+			},
+			compute: func(env Environment, args string) (string, error) {
+				minValue, maxValue, _ := parseRange(args)
+				return strconv.Itoa(env.Random(minValue, maxValue)), nil
+			},
+		},
+	}
+)
+
+func anyArgs(string) bool {
+	return true
+}
+
+func formatNow(defaultLayout string) func(Environment, string) (string, error) {
+	return func(env Environment, layout string) (string, error) {
+		if layout == "" {
+			layout = defaultLayout
+		}
+		return env.Now().Format(layout), nil
+	}
+}
+
+// parseBuiltin recognises "{@name}" and "{@name:args}" for a known builtin.
+// isBuiltin is false for other bodies, such as the "{@handle}" shorthand.
+func parseBuiltin(body string) (spec Spec, ok, isBuiltin bool) {
+	rest, found := strings.CutPrefix(body, handlePrefix)
+	if !found {
+		return Spec{}, false, false
+	}
+
+	name, args, _ := strings.Cut(rest, ":")
+	definition, found := builtins[name]
+	if !found {
+		return Spec{}, false, false
+	}
+	if !definition.check(args) {
+		return Spec{}, false, true
+	}
+
+	return Spec{Kind: KindComputed, Builtin: name, Args: args}, true, true
+}
+
+// SetEnvironment replaces the inputs used for computed placeholders. Unset
+// functions keep their current behaviour.
+func (s *Session) SetEnvironment(env Environment) {
+	if env.Now != nil {
+		s.env.Now = env.Now
+	}
+	if env.Random != nil {
+		s.env.Random = env.Random
+	}
+	if env.Counter != nil {
+		s.env.Counter = env.Counter
+	}
+}
+
+// computeValues returns a copy of values with every computed placeholder
+// filled in. Each is computed once, however often it appears. Expressions
+// read the answers to the prompted placeholders.
+func (s *Session) computeValues(values []Value) ([]Value, error) {
+	answers := make(map[string]string, len(s.placeholders))
+	for i, placeholder := range s.placeholders {
+		if placeholder.Name != "" && !placeholder.Kind.IsComputed() {
+			answers[placeholder.Name] = values[i].String()
+		}
+	}
+	lookup := func(name string) string { return answers[name] }
+
+	result := append([]Value(nil), values...)
+	for i, placeholder := range s.placeholders {
+		if !placeholder.Kind.IsComputed() {
+			continue
+		}
+
+		var (
+			value string
+			err   error
+		)
+		if placeholder.Expr != nil {
+			value, err = placeholder.Expr.Eval(lookup)
+		} else {
+			value, err = builtins[placeholder.Builtin].compute(s.env, placeholder.Args)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s: %w", placeholder.Pos, placeholder.Name, err)
+		}
+		result[i] = TextValue(value)
+	}
+	return result, nil
+}
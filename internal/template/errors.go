@@ -0,0 +1,74 @@
+package template
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// ParseError reports a syntax error in a template body together with the
+// offending line, so that it can be shown with a caret under the column.
+type ParseError struct {
+	Pos Pos
+	// Line is the source line containing Pos, without its line break.
+	Line string
+	Err  error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Pos, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// Excerpt returns the offending line followed by a caret under the column.
+// Wide characters are counted as two columns and tabs are kept so that the
+// caret lines up in a terminal.
+func (e *ParseError) Excerpt() string {
+	var padding strings.Builder
+	for i, r := range []rune(e.Line) {
+		if i >= e.Pos.Column-1 {
+			break
+		}
+		switch {
+		case r == '\t':
+			padding.WriteRune('\t')
+		case isWide(r):
+			padding.WriteString("  ")
+		default:
+			padding.WriteByte(' ')
+		}
+	}
+	return e.Line + "\n" + padding.String() + "^"
+}
+
+// isWide reports whether r is drawn two columns wide by a typical terminal.
+func isWide(r rune) bool {
+	switch {
+	case unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul):
+		return r < 0xFF61 || r > 0xFF9F // half-width katakana are narrow
+	case r >= 0x3000 && r <= 0x303F, r >= 0xFF01 && r <= 0xFF60, r >= 0xFFE0 && r <= 0xFFE6:
+		return true
+	case r >= 0x1F300 && r <= 0x1FAFF:
+		return true
+	default:
+		return false
+	}
+}
+
+// errorf returns a ParseError at offset.
+func (p *parser) errorf(offset int, format string, args ...any) error {
+	pos := p.pos(offset)
+	lineStart := p.lineStarts[pos.Line-1]
+	lineEnd := strings.IndexByte(p.src[lineStart:], '\n')
+	if lineEnd < 0 {
+		lineEnd = len(p.src) - lineStart
+	}
+	return &ParseError{
+		Pos:  pos,
+		Line: strings.TrimSuffix(p.src[lineStart:lineStart+lineEnd], "\r"),
+		Err:  fmt.Errorf(format, args...),
+	}
+}
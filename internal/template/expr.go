@@ -0,0 +1,492 @@
+package template
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// exprMark starts an expression placeholder such as "{= 好感度 * 10}".
+const exprMark = "="
+
+// Expr is a compiled expression. The language is deliberately small: number,
+// string and boolean literals, placeholder names, arithmetic, comparison,
+// logical operators and the ternary "cond ? a : b". It cannot call functions
+// or reach anything but the answers.
+type Expr struct {
+	Source string
+	root   exprNode
+	// names lists the placeholders the expression reads, in order.
+	names []string
+}
+
+// Names returns the placeholder names the expression refers to.
+func (e *Expr) Names() []string {
+	return append([]string(nil), e.names...)
+}
+
+// Eval evaluates the expression, looking up answers with lookup, and formats
+// the result as text.
+func (e *Expr) Eval(lookup func(name string) string) (string, error) {
+	value, err := e.root.eval(lookup)
+	if err != nil {
+		return "", err
+	}
+	return formatExprValue(value), nil
+}
+
+// exprNode is a node of the expression tree. Values are float64, string or bool.
+type exprNode interface {
+	eval(lookup func(string) string) (any, error)
+}
+
+type (
+	literalExpr struct{ value any }
+	nameExpr    struct{ name string }
+	unaryExpr   struct {
+		op      string
+		operand exprNode
+	}
+	binaryExpr struct {
+		op          string
+		left, right exprNode
+	}
+	ternaryExpr struct{ cond, then, otherwise exprNode }
+)
+
+func (n literalExpr) eval(func(string) string) (any, error) {
+	return n.value, nil
+}
+
+func (n nameExpr) eval(lookup func(string) string) (any, error) {
+	return lookup(n.name), nil
+}
+
+func (n unaryExpr) eval(lookup func(string) string) (any, error) {
+	value, err := n.operand.eval(lookup)
+	if err != nil {
+		return nil, err
+	}
+	if n.op == "!" {
+		return !truthy(value), nil
+	}
+	number, err := toNumber(value)
+	if err != nil {
+		return nil, err
+	}
+	return -number, nil
+}
+
+func (n binaryExpr) eval(lookup func(string) string) (any, error) {
+	left, err := n.left.eval(lookup)
+	if err != nil {
+		return nil, err
+	}
+	// Logical operators short-circuit and yield the deciding operand.
+	switch n.op {
+	case "&&":
+		if !truthy(left) {
+			return left, nil
+		}
+		return n.right.eval(lookup)
+	case "||":
+		if truthy(left) {
+			return left, nil
+		}
+		return n.right.eval(lookup)
+	}
+
+	right, err := n.right.eval(lookup)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "==", "!=":
+		equal := compareValues(left, right) == 0
+		return equal == (n.op == "=="), nil
+	case "<", "<=", ">", ">=":
+		cmp := compareValues(left, right)
+		switch n.op {
+		case "<":
+			return cmp < 0, nil
+		case "<=":
+			return cmp <= 0, nil
+		case ">":
+			return cmp > 0, nil
+		default:
+			return cmp >= 0, nil
+		}
+	case "+":
+		// "+" adds numbers and concatenates anything else.
+		if a, b, ok := bothNumbers(left, right); ok {
+			return a + b, nil
+		}
+		return formatExprValue(left) + formatExprValue(right), nil
+	}
+
+	a, err := toNumber(left)
+	if err != nil {
+		return nil, err
+	}
+	b, err := toNumber(right)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "-":
+		return a - b, nil
+	case "*":
+		return a * b, nil
+	case "/":
+		if b == 0 {
+			return nil, errors.New("division by zero")
+		}
+		return a / b, nil
+	default: // "%"
+		if b == 0 {
+			return nil, errors.New("division by zero")
+		}
+		return math.Mod(a, b), nil
+	}
+}
+
+func (n ternaryExpr) eval(lookup func(string) string) (any, error) {
+	cond, err := n.cond.eval(lookup)
+	if err != nil {
+		return nil, err
+	}
+	if truthy(cond) {
+		return n.then.eval(lookup)
+	}
+	return n.otherwise.eval(lookup)
+}
+
+// toNumber converts a value for arithmetic. An empty answer counts as zero so
+// that skipped placeholders do not break the template.
+func toNumber(value any) (float64, error) {
+	switch v := value.(type) {
+	case float64:
+		return v, nil
+	case bool:
+		if v {
+			return 1, nil
+		}
+		return 0, nil
+	case string:
+		text := strings.TrimSpace(v)
+		if text == "" {
+			return 0, nil
+		}
+		number, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return 0, fmt.Errorf("%q is not a number", text)
+		}
+		return number, nil
+	default:
+		return 0, fmt.Errorf("%v is not a number", v)
+	}
+}
+
+// bothNumbers reports whether both values are numbers or non-empty text that
+// reads as a number.
+func bothNumbers(left, right any) (float64, float64, bool) {
+	a, okA := asNumber(left)
+	b, okB := asNumber(right)
+	return a, b, okA && okB
+}
+
+func asNumber(value any) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case string:
+		number, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return number, err == nil
+	default:
+		return 0, false
+	}
+}
+
+// compareValues compares numerically when both values read as numbers and as
+// text otherwise.
+func compareValues(left, right any) int {
+	if a, b, ok := bothNumbers(left, right); ok {
+		switch {
+		case a < b:
+			return -1
+		case a > b:
+			return 1
+		default:
+			return 0
+		}
+	}
+	return strings.Compare(formatExprValue(left), formatExprValue(right))
+}
+
+// truthy follows the rules of "{?name}" sections for text, so that "いいえ" or
+// "0" are false, and treats zero as false.
+func truthy(value any) bool {
+	switch v := value.(type) {
+	case bool:
+		return v
+	case float64:
+		return v != 0
+	case string:
+		text := strings.TrimSpace(v)
+		return text != "" && !falsyAnswers[strings.ToLower(text)]
+	default:
+		return false
+	}
+}
+
+func formatExprValue(value any) string {
+	switch v := value.(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case string:
+		return v
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// exprOperators lists the operators longest first so that "<=" wins over "<".
+var exprOperators = []string{"&&", "||", "==", "!=", "<=", ">=", "<", ">", "+", "-", "*", "/", "%", "!", "?", ":", "(", ")"}
+
+type exprToken struct {
+	offset int
+	kind   byte // 'n' number, 's' string, 'i' identifier, 'o' operator
+	text   string
+	number float64
+}
+
+// exprError reports a syntax error at a byte offset within an expression.
+type exprError struct {
+	offset int
+	msg    string
+}
+
+func (e *exprError) Error() string {
+	return e.msg
+}
+
+func lexExpr(src string) ([]exprToken, error) {
+	tokens := make([]exprToken, 0)
+	for i := 0; i < len(src); {
+		r, width := utf8.DecodeRuneInString(src[i:])
+		switch {
+		case unicode.IsSpace(r):
+			i += width
+			continue
+		case r == '"' || r == '\'':
+			end := strings.IndexRune(src[i+width:], r)
+			if end < 0 {
+				return nil, &exprError{i, "string is not closed"}
+			}
+			tokens = append(tokens, exprToken{offset: i, kind: 's', text: src[i+width : i+width+end]})
+			i += width + end + width
+			continue
+		case r >= '0' && r <= '9':
+			end := i
+			for end < len(src) && (src[end] >= '0' && src[end] <= '9' || src[end] == '.') {
+				end++
+			}
+			number, err := strconv.ParseFloat(src[i:end], 64)
+			if err != nil {
+				return nil, &exprError{i, fmt.Sprintf("invalid number %q", src[i:end])}
+			}
+			tokens = append(tokens, exprToken{offset: i, kind: 'n', text: src[i:end], number: number})
+			i = end
+			continue
+		}
+
+		operator := ""
+		for _, op := range exprOperators {
+			if strings.HasPrefix(src[i:], op) {
+				operator = op
+				break
+			}
+		}
+		if operator != "" {
+			tokens = append(tokens, exprToken{offset: i, kind: 'o', text: operator})
+			i += len(operator)
+			continue
+		}
+
+		end := i
+		for end < len(src) {
+			r, width := utf8.DecodeRuneInString(src[end:])
+			if unicode.IsSpace(r) || r == '-' || strings.ContainsRune(nameReserved, r) {
+				break
+			}
+			end += width
+		}
+		if end == i {
+			return nil, &exprError{i, fmt.Sprintf("unexpected %q", r)}
+		}
+		tokens = append(tokens, exprToken{offset: i, kind: 'i', text: src[i:end]})
+		i = end
+	}
+	return tokens, nil
+}
+
+type exprParser struct {
+	src    string
+	tokens []exprToken
+	pos    int
+	names  []string
+	seen   map[string]bool
+}
+
+// parseExpr compiles an expression. Errors are *exprError carrying the offset
+// of the offending token.
+func parseExpr(src string) (*Expr, error) {
+	tokens, err := lexExpr(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{src: src, tokens: tokens, seen: make(map[string]bool)}
+	if len(tokens) == 0 {
+		return nil, &exprError{0, "empty expression"}
+	}
+	root, err := p.ternary()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		tok := p.tokens[p.pos]
+		return nil, &exprError{tok.offset, fmt.Sprintf("unexpected %q", tok.text)}
+	}
+	return &Expr{Source: src, root: root, names: p.names}, nil
+}
+
+func (p *exprParser) peekOperator(ops ...string) (string, bool) {
+	if p.pos >= len(p.tokens) || p.tokens[p.pos].kind != 'o' {
+		return "", false
+	}
+	for _, op := range ops {
+		if p.tokens[p.pos].text == op {
+			p.pos++
+			return op, true
+		}
+	}
+	return "", false
+}
+
+func (p *exprParser) ternary() (exprNode, error) {
+	cond, err := p.binary(0)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := p.peekOperator("?"); !ok {
+		return cond, nil
+	}
+	then, err := p.ternary()
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := p.peekOperator(":"); !ok {
+		return nil, p.errorHere("expected \":\" in conditional expression")
+	}
+	otherwise, err := p.ternary()
+	if err != nil {
+		return nil, err
+	}
+	return ternaryExpr{cond: cond, then: then, otherwise: otherwise}, nil
+}
+
+// binaryLevels lists the binary operators from the loosest to the tightest.
+var binaryLevels = [][]string{
+	{"||"},
+	{"&&"},
+	{"==", "!="},
+	{"<", "<=", ">", ">="},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+func (p *exprParser) binary(level int) (exprNode, error) {
+	if level == len(binaryLevels) {
+		return p.unary()
+	}
+	left, err := p.binary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.peekOperator(binaryLevels[level]...)
+		if !ok {
+			return left, nil
+		}
+		right, err := p.binary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = binaryExpr{op: op, left: left, right: right}
+	}
+}
+
+func (p *exprParser) unary() (exprNode, error) {
+	if op, ok := p.peekOperator("!", "-"); ok {
+		operand, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return unaryExpr{op: op, operand: operand}, nil
+	}
+	return p.primary()
+}
+
+func (p *exprParser) primary() (exprNode, error) {
+	if p.pos >= len(p.tokens) {
+		return nil, p.errorHere("unexpected end of expression")
+	}
+	tok := p.tokens[p.pos]
+	p.pos++
+
+	switch tok.kind {
+	case 'n':
+		return literalExpr{tok.number}, nil
+	case 's':
+		return literalExpr{tok.text}, nil
+	case 'i':
+		switch tok.text {
+		case "true":
+			return literalExpr{true}, nil
+		case "false":
+			return literalExpr{false}, nil
+		}
+		if !p.seen[tok.text] {
+			p.seen[tok.text] = true
+			p.names = append(p.names, tok.text)
+		}
+		return nameExpr{tok.text}, nil
+	}
+
+	if tok.text == "(" {
+		inner, err := p.ternary()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := p.peekOperator(")"); !ok {
+			return nil, p.errorHere("expected \")\"")
+		}
+		return inner, nil
+	}
+	return nil, &exprError{tok.offset, fmt.Sprintf("unexpected %q", tok.text)}
+}
+
+func (p *exprParser) errorHere(msg string) error {
+	offset := len(p.src)
+	if p.pos < len(p.tokens) {
+		offset = p.tokens[p.pos].offset
+	}
+	return &exprError{offset, msg}
+}
@@ -0,0 +1,186 @@
+package template
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// blockTagPattern matches the tags that open or close a block, "{$name}" and
+// "{/}", together with the section tags that share the closing tag and the
+// escape sequences that hide a tag.
+var blockTagPattern = regexp.MustCompile(`\\[\\{}]|\{[?#$][^{}\n]*\}|\{/\}`)
+
+const blockMark = '$'
+
+// block locates a "{$name}...{/}" block within a template body by byte
+// offsets: the block spans [start, end) and its content [contentStart,
+// contentEnd).
+type block struct {
+	name                     string
+	start, end               int
+	contentStart, contentEnd int
+}
+
+// findBlocks returns the blocks of a template body in source order, outer
+// blocks before the blocks they contain.
+func findBlocks(body string) ([]block, error) {
+	blocks := make([]block, 0)
+	// open holds the index into blocks of each open block tag, or -1 for an
+	// open section, innermost last.
+	open := make([]int, 0)
+	for _, loc := range blockTagPattern.FindAllStringIndex(body, -1) {
+		tag := body[loc[0]:loc[1]]
+		switch {
+		case tag[0] == escapeMark:
+		case tag == "{/}":
+			if len(open) == 0 {
+				continue
+			}
+			if idx := open[len(open)-1]; idx >= 0 {
+				blocks[idx].contentEnd = loc[0]
+				blocks[idx].end = loc[1]
+			}
+			open = open[:len(open)-1]
+		case tag[1] == blockMark:
+			open = append(open, len(blocks))
+			blocks = append(blocks, block{name: tag[2 : len(tag)-1], start: loc[0], contentStart: loc[1]})
+		default:
+			open = append(open, -1)
+		}
+	}
+
+	for _, b := range blocks {
+		if b.end == 0 {
+			return nil, fmt.Errorf("block %q is not closed with {/}", b.name)
+		}
+	}
+	return blocks, nil
+}
+
+// extend builds the document described by child, which extends parent: the
+// blocks of the parent's template are replaced by those the child overrides,
+// and the child's title, description, fields and markers take precedence.
+func extend(parent, child Document) (Document, error) {
+	if strings.TrimSpace(child.Template) != "" {
+		return Document{}, fmt.Errorf("a template that extends %q overrides blocks instead of defining its own template", child.Extends)
+	}
+
+	blocks, err := findBlocks(parent.Template)
+	if err != nil {
+		return Document{}, err
+	}
+	known := make(map[string]bool, len(blocks))
+	for _, b := range blocks {
+		known[b.name] = true
+	}
+	for name := range child.Blocks {
+		if !known[name] {
+			return Document{}, fmt.Errorf("block %q is not defined in %q", name, child.Extends)
+		}
+	}
+
+	// An overridden block replaces any blocks nested inside it, so only the
+	// outermost overrides are applied, from the end to keep offsets valid.
+	overrides := make([]block, 0, len(child.Blocks))
+	for _, b := range blocks {
+		if _, ok := child.Blocks[b.name]; !ok {
+			continue
+		}
+		if n := len(overrides); n > 0 && b.start < overrides[n-1].end {
+			continue
+		}
+		overrides = append(overrides, b)
+	}
+	body := parent.Template
+	for i := len(overrides) - 1; i >= 0; i-- {
+		b := overrides[i]
+		content := strings.TrimSuffix(child.Blocks[b.name], "\n")
+		// Keep the tags on lines of their own when the parent wrote them so.
+		if original := body[b.contentStart:b.contentEnd]; strings.HasPrefix(original, "\n") && strings.HasSuffix(original, "\n") {
+			content = "\n" + content + "\n"
+		}
+		body = body[:b.contentStart] + content + body[b.contentEnd:]
+	}
+
+	result := parent
+	result.Template = body
+	result.Extends = ""
+	result.Blocks = nil
+	if child.Title != "" {
+		result.Title = child.Title
+	}
+	if child.Description != "" {
+		result.Description = child.Description
+	}
+	if len(child.Fields) > 0 {
+		result.Fields = make(map[string]Field, len(parent.Fields)+len(child.Fields))
+		for name, field := range parent.Fields {
+			result.Fields[name] = field
+		}
+		for name, field := range child.Fields {
+			result.Fields[name] = field
+		}
+	}
+	if child.Markers.Checked != "" {
+		result.Markers.Checked = child.Markers.Checked
+	}
+	if child.Markers.Unchecked != "" {
+		result.Markers.Unchecked = child.Markers.Unchecked
+	}
+	if child.Optional {
+		result.Optional = true
+	}
+	return result, nil
+}
+
+// stripBlocks removes the block tags of a fully resolved template, keeping
+// their content. A tag on a line of its own is removed with its line break.
+func stripBlocks(body string) (string, error) {
+	blocks, err := findBlocks(body)
+	if err != nil {
+		return "", err
+	}
+	// Cut the tags from the end so that earlier offsets stay valid; nested
+	// blocks interleave, so the tags are ordered by offset first.
+	tags := make([][2]int, 0, 2*len(blocks))
+	for _, b := range blocks {
+		tags = append(tags, [2]int{b.start, b.contentStart}, [2]int{b.contentEnd, b.end})
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i][0] < tags[j][0] })
+	for i, tag := range tags {
+		if tag[0] > 0 && body[tag[0]-1] != '\n' {
+			continue
+		}
+		switch {
+		case tag[1] < len(body) && body[tag[1]] == '\n':
+			tags[i][1]++
+		case tag[1] == len(body) && tag[0] > 0 && (i == 0 || tags[i-1][1] < tag[0]):
+			tags[i][0]--
+		}
+	}
+	for i := len(tags) - 1; i >= 0; i-- {
+		body = body[:tags[i][0]] + body[tags[i][1]:]
+	}
+	return body, nil
+}
+
+// resolveExtends loads the parent of doc, which was read from path, and
+// returns the extended document.
+func (l *loader) resolveExtends(doc Document, path string) (Document, error) {
+	parentPath, err := findPartial(doc.Extends, filepath.Dir(path))
+	if err != nil {
+		return Document{}, fmt.Errorf("%s: %w", path, err)
+	}
+	parent, err := l.loadSingle(parentPath)
+	if err != nil {
+		return Document{}, err
+	}
+	extended, err := extend(parent, doc)
+	if err != nil {
+		return Document{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return extended, nil
+}
@@ -0,0 +1,390 @@
+package template
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Filter is one step of a placeholder's filter pipeline, such as "truncate 10".
+type Filter struct {
+	Pos  Pos
+	Name string
+	Args []string
+	fn   func(string) string
+}
+
+// Apply transforms a rendered answer.
+func (f Filter) Apply(value string) string {
+	return f.fn(value)
+}
+
+// filterFactory validates a filter's arguments and returns the transformation.
+type filterFactory func(args []string) (func(string) string, error)
+
+// filters is the table of available filters. To add one, register a factory
+// under the name used in templates.
+var filters = map[string]filterFactory{
+	"trim":      noArgs(strings.TrimSpace),
+	"upper":     noArgs(strings.ToUpper),
+	"lower":     noArgs(strings.ToLower),
+	"fullwidth": noArgs(toFullwidth),
+	"halfwidth": noArgs(toHalfwidth),
+	"katakana":  noArgs(toKatakana),
+	"hiragana":  noArgs(toHiragana),
+	"truncate":  truncateFilter,
+	"wrap":      wrapFilter,
+	"quote":     quoteFilter,
+}
+
+// FilterNames returns the names of the registered filters in sorted order.
+func FilterNames() []string {
+	names := make([]string, 0, len(filters))
+	for name := range filters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// filterSeparator starts a pipeline when preceded by a space, so that choice
+// options written "a|b" are not mistaken for filters.
+const filterSeparator = " |"
+
+// cutPipeline splits a placeholder body at the start of its filter pipeline,
+// which is the first " |" followed by a filter name. Choice options written
+// "a | b" therefore stay options. A body without a spec cannot hold options,
+// so there the first " |" starts the pipeline even before an unknown name,
+// which is then reported. The "||" operator of expressions does not start a
+// pipeline.
+func cutPipeline(body string) (string, string, bool) {
+	fallback := -1
+	for start := 0; ; {
+		idx := strings.Index(body[start:], filterSeparator)
+		if idx < 0 {
+			break
+		}
+		idx += start
+		end := idx + len(filterSeparator)
+		if strings.HasPrefix(body[end:], "|") {
+			start = end + 1
+			continue
+		}
+		start = end
+
+		if fallback < 0 {
+			fallback = idx
+		}
+		rest := strings.TrimLeft(body[end:], " ")
+		if nameEnd := strings.IndexAny(rest, " |"); nameEnd >= 0 {
+			rest = rest[:nameEnd]
+		}
+		if _, ok := filters[rest]; ok {
+			return body[:idx], body[end:], true
+		}
+	}
+
+	if fallback >= 0 && !strings.Contains(body[:fallback], ":") {
+		return body[:fallback], body[fallback+len(filterSeparator):], true
+	}
+	return body, "", false
+}
+
+// parseFilters compiles a "|"-separated pipeline that starts at offset.
+func (p *parser) parseFilters(offset int, pipeline string) ([]Filter, error) {
+	result := make([]Filter, 0)
+	for _, segment := range strings.Split(pipeline, "|") {
+		trimmed := strings.TrimLeft(segment, " ")
+		start := offset + len(segment) - len(trimmed)
+		offset += len(segment) + 1
+
+		fields := strings.Fields(trimmed)
+		if len(fields) == 0 {
+			return nil, p.errorf(start, "empty filter")
+		}
+
+		factory, ok := filters[fields[0]]
+		if !ok {
+			return nil, p.errorf(start, "unknown filter %q (available: %s)", fields[0], strings.Join(FilterNames(), ", "))
+		}
+		fn, err := factory(fields[1:])
+		if err != nil {
+			return nil, p.errorf(start, "filter %q: %w", fields[0], err)
+		}
+
+		result = append(result, Filter{Pos: p.pos(start), Name: fields[0], Args: fields[1:], fn: fn})
+	}
+	return result, nil
+}
+
+func applyFilters(pipeline []Filter, value string) string {
+	for _, filter := range pipeline {
+		value = filter.Apply(value)
+	}
+	return value
+}
+
+func noArgs(fn func(string) string) filterFactory {
+	return func(args []string) (func(string) string, error) {
+		if len(args) > 0 {
+			return nil, errors.New("takes no arguments")
+		}
+		return fn, nil
+	}
+}
+
+func positiveArg(args []string, usage string) (int, error) {
+	if len(args) == 0 {
+		return 0, fmt.Errorf("usage: %s", usage)
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("%q is not a positive integer (usage: %s)", args[0], usage)
+	}
+	return n, nil
+}
+
+// truncateFilter keeps at most N characters, counting user-perceived
+// characters rather than code points. An optional suffix such as "…" is
+// appended when text is cut and counts towards the limit.
+func truncateFilter(args []string) (func(string) string, error) {
+	const usage = "truncate N [suffix]"
+	limit, err := positiveArg(args, usage)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) > 2 {
+		return nil, fmt.Errorf("usage: %s", usage)
+	}
+	suffix := ""
+	if len(args) == 2 {
+		suffix = args[1]
+	}
+	suffixLength := len(graphemes(suffix))
+	if suffixLength >= limit {
+		return nil, fmt.Errorf("suffix %q does not fit in %d characters", suffix, limit)
+	}
+
+	return func(value string) string {
+		clusters := graphemes(value)
+		if len(clusters) <= limit {
+			return value
+		}
+		return strings.Join(clusters[:limit-suffixLength], "") + suffix
+	}, nil
+}
+
+// wrapFilter breaks lines longer than N characters. Japanese text has no
+// spaces to break at, so lines are cut at exactly N characters.
+func wrapFilter(args []string) (func(string) string, error) {
+	const usage = "wrap N"
+	width, err := positiveArg(args, usage)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) > 1 {
+		return nil, fmt.Errorf("usage: %s", usage)
+	}
+
+	return func(value string) string {
+		lines := strings.Split(value, "\n")
+		for i, line := range lines {
+			clusters := graphemes(line)
+			var builder strings.Builder
+			for j, cluster := range clusters {
+				if j > 0 && j%width == 0 {
+					builder.WriteByte('\n')
+				}
+				builder.WriteString(cluster)
+			}
+			lines[i] = builder.String()
+		}
+		return strings.Join(lines, "\n")
+	}, nil
+}
+
+// quoteFilter surrounds the value with a pair of quotes, 「」 by default.
+func quoteFilter(args []string) (func(string) string, error) {
+	open, closing := "「", "」"
+	switch len(args) {
+	case 0:
+	case 1:
+		pair := []rune(args[0])
+		if len(pair) != 2 {
+			return nil, errors.New("quotes must be a pair of characters such as 『』")
+		}
+		open, closing = string(pair[0]), string(pair[1])
+	default:
+		return nil, errors.New("usage: quote [pair]")
+	}
+
+	return func(value string) string {
+		return open + value + closing
+	}, nil
+}
+
+// graphemes splits text into user-perceived characters. It is an
+// approximation of Unicode text segmentation that keeps combining marks,
+// variation selectors, emoji modifiers, zero-width-joiner sequences and
+// regional-indicator flags together.
+func graphemes(text string) []string {
+	clusters := make([]string, 0, utf8.RuneCountInString(text))
+	start := 0
+	var prev rune
+	regional := 0
+	for i, r := range text {
+		if i > 0 && !extendsCluster(prev, r, regional) {
+			clusters = append(clusters, text[start:i])
+			start = i
+			regional = 0
+		}
+		if isRegionalIndicator(r) {
+			regional++
+		}
+		prev = r
+	}
+	if start < len(text) {
+		clusters = append(clusters, text[start:])
+	}
+	return clusters
+}
+
+func extendsCluster(prev, r rune, regional int) bool {
+	switch {
+	case prev == zeroWidthJoiner, r == zeroWidthJoiner:
+		return true
+	case unicode.In(r, unicode.Mn, unicode.Me, unicode.Mc):
+		return true
+	case unicode.Is(unicode.Variation_Selector, r):
+		return true
+	case r >= 0x1F3FB && r <= 0x1F3FF:
+		return true
+	case isRegionalIndicator(r) && isRegionalIndicator(prev):
+		return regional%2 == 1
+	default:
+		return false
+	}
+}
+
+func isRegionalIndicator(r rune) bool {
+	return r >= 0x1F1E6 && r <= 0x1F1FF
+}
+
+const (
+	zeroWidthJoiner  = '\u200d'
+	fullwidthOffset  = 0xFEE0
+	ideographicSpace = '　'
+	kanaOffset       = 0x60
+)
+
+// halfwidthKana and fullwidthKana map U+FF61–U+FF9F to their full-width forms.
+const (
+	halfwidthKana = "｡｢｣､･ｦｧｨｩｪｫｬｭｮｯｰｱｲｳｴｵｶｷｸｹｺｻｼｽｾｿﾀﾁﾂﾃﾄﾅﾆﾇﾈﾉﾊﾋﾌﾍﾎﾏﾐﾑﾒﾓﾔﾕﾖﾗﾘﾙﾚﾛﾜﾝﾞﾟ"
+	fullwidthKana = "。「」、・ヲァィゥェォャュョッーアイウエオカキクケコサシスセソタチツテトナニヌネノハヒフヘホマミムメモヤユヨラリルレロワン゛゜"
+	// voicedBases can take a dakuten; semiVoicedBases can also take a handakuten.
+	voicedBases     = "カキクケコサシスセソタチツテトハヒフヘホ"
+	semiVoicedBases = "ハヒフヘホ"
+)
+
+var (
+	kanaToFull = make(map[rune]rune)
+	kanaToHalf = make(map[rune]string)
+)
+
+func init() {
+	half, full := []rune(halfwidthKana), []rune(fullwidthKana)
+	for i := range half {
+		kanaToFull[half[i]] = full[i]
+		kanaToHalf[full[i]] = string(half[i])
+	}
+	for _, base := range voicedBases {
+		kanaToHalf[base+1] = kanaToHalf[base] + "ﾞ"
+	}
+	for _, base := range semiVoicedBases {
+		kanaToHalf[base+2] = kanaToHalf[base] + "ﾟ"
+	}
+	kanaToHalf['ヴ'] = "ｳﾞ"
+}
+
+// toFullwidth converts ASCII and half-width katakana to their full-width forms,
+// combining a following sound mark into voiced kana such as "ｶﾞ" → "ガ".
+func toFullwidth(value string) string {
+	runes := []rune(value)
+	var builder strings.Builder
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case r == ' ':
+			builder.WriteRune(ideographicSpace)
+		case r > ' ' && r <= '~':
+			builder.WriteRune(r + fullwidthOffset)
+		case kanaToFull[r] != 0:
+			full := kanaToFull[r]
+			if i+1 < len(runes) {
+				if combined, ok := combineSoundMark(full, runes[i+1]); ok {
+					full = combined
+					i++
+				}
+			}
+			builder.WriteRune(full)
+		default:
+			builder.WriteRune(r)
+		}
+	}
+	return builder.String()
+}
+
+func combineSoundMark(base, mark rune) (rune, bool) {
+	switch {
+	case mark == 'ﾞ' && base == 'ウ':
+		return 'ヴ', true
+	case mark == 'ﾞ' && strings.ContainsRune(voicedBases, base):
+		return base + 1, true
+	case mark == 'ﾟ' && strings.ContainsRune(semiVoicedBases, base):
+		return base + 2, true
+	default:
+		return 0, false
+	}
+}
+
+// toHalfwidth converts full-width ASCII and katakana to their half-width forms.
+func toHalfwidth(value string) string {
+	var builder strings.Builder
+	for _, r := range value {
+		switch {
+		case r == ideographicSpace:
+			builder.WriteByte(' ')
+		case r > ' '+fullwidthOffset && r <= '~'+fullwidthOffset:
+			builder.WriteRune(r - fullwidthOffset)
+		case kanaToHalf[r] != "":
+			builder.WriteString(kanaToHalf[r])
+		default:
+			builder.WriteRune(r)
+		}
+	}
+	return builder.String()
+}
+
+// toKatakana converts hiragana to katakana.
+func toKatakana(value string) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'ぁ' && r <= 'ゖ') || r == 'ゝ' || r == 'ゞ' {
+			return r + kanaOffset
+		}
+		return r
+	}, value)
+}
+
+// toHiragana converts katakana to hiragana.
+func toHiragana(value string) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'ァ' && r <= 'ヶ') || r == 'ヽ' || r == 'ヾ' {
+			return r - kanaOffset
+		}
+		return r
+	}, value)
+}
@@ -0,0 +1,175 @@
+package template
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// LibraryEnv lists extra directories, separated like PATH, that are searched
+// for partials not found next to the including file.
+const LibraryEnv = "TWITTER_DORE_LIBRARY"
+
+// includePattern matches "{>name}", which is replaced with the template body
+// of the partial name.yaml. The name may contain a relative path such as
+// "{>common/footer}". Escape sequences are matched too so that "\{>name}"
+// is skipped.
+var includePattern = regexp.MustCompile(`\\[\\{}]|\{>([^{}\s]+)\}`)
+
+// partialExtensions are tried in order when resolving a partial name.
+var partialExtensions = []string{".yaml", ".yml"}
+
+// loader loads documents and expands their includes and parents, tracking the
+// chain of files being loaded so that cycles can be reported. Block tags are
+// kept so that a document extending the result can still override them.
+type loader struct {
+	stack []string
+}
+
+// load reads every template in the file at path with its includes and parent
+// resolved.
+func (l *loader) load(path string) ([]Document, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	for i, loading := range l.stack {
+		if loading == abs {
+			chain := append(append([]string(nil), l.stack[i:]...), abs)
+			return nil, fmt.Errorf("include cycle: %s", strings.Join(chain, " -> "))
+		}
+	}
+	l.stack = append(l.stack, abs)
+	defer func() { l.stack = l.stack[:len(l.stack)-1] }()
+
+	docs, err := decodeFile(path)
+	if err != nil {
+		return nil, err
+	}
+	for i := range docs {
+		if err := l.expandIncludes(&docs[i], path); err != nil {
+			return nil, err
+		}
+		if docs[i].Extends != "" {
+			if docs[i], err = l.resolveExtends(docs[i], path); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return docs, nil
+}
+
+// loadSingle loads a partial or parent, which must hold exactly one template.
+func (l *loader) loadSingle(path string) (Document, error) {
+	docs, err := l.load(path)
+	if err != nil {
+		return Document{}, err
+	}
+	if len(docs) != 1 {
+		return Document{}, fmt.Errorf("%s: %w: it holds %d templates", path, ErrBundle, len(docs))
+	}
+	return docs[0], nil
+}
+
+// expandIncludes replaces every "{>name}" in the template body and in the
+// block overrides with the body of the partial, and adopts the partial's
+// field declarations for names the document does not declare itself.
+func (l *loader) expandIncludes(doc *Document, path string) error {
+	var err error
+	if doc.Template, err = l.expandBody(doc, doc.Template, path); err != nil {
+		return err
+	}
+	for name, block := range doc.Blocks {
+		if doc.Blocks[name], err = l.expandBody(doc, block, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// expandBody expands the includes of one body of doc.
+func (l *loader) expandBody(doc *Document, body, path string) (string, error) {
+	var expandErr error
+	body = includePattern.ReplaceAllStringFunc(body, func(token string) string {
+		name := includePattern.FindStringSubmatch(token)[1]
+		if expandErr != nil || name == "" {
+			return token
+		}
+		partialPath, err := findPartial(name, filepath.Dir(path))
+		if err != nil {
+			expandErr = fmt.Errorf("%s: %w", path, err)
+			return token
+		}
+		partial, err := l.loadSingle(partialPath)
+		if err != nil {
+			expandErr = err
+			return token
+		}
+		// Check the partial on its own, so that a syntax error in it is
+		// reported against its file rather than the flattened body.
+		if err := checkSyntax(partial.Template); err != nil {
+			expandErr = fmt.Errorf("%s: %w", partialPath, err)
+			return token
+		}
+
+		for fieldName, field := range partial.Fields {
+			if _, ok := doc.Fields[fieldName]; ok {
+				continue
+			}
+			if doc.Fields == nil {
+				doc.Fields = make(map[string]Field)
+			}
+			doc.Fields[fieldName] = field
+		}
+		// A partial usually ends with a newline of its own; the line of the
+		// include tag already provides one.
+		return strings.TrimSuffix(partial.Template, "\n")
+	})
+	return body, expandErr
+}
+
+// checkSyntax parses a body with its block tags removed, as LoadBundle
+// leaves it.
+func checkSyntax(body string) error {
+	body, err := stripBlocks(body)
+	if err != nil {
+		return err
+	}
+	_, err = parse(body)
+	return err
+}
+
+// findPartial looks for the partial next to the including file first, then in
+// the library directories. A name that already has a YAML extension is used
+// as is.
+func findPartial(name, dir string) (string, error) {
+	extensions := partialExtensions
+	for _, ext := range partialExtensions {
+		if strings.HasSuffix(name, ext) {
+			extensions = []string{""}
+		}
+	}
+
+	dirs := []string{dir}
+	for _, libraryDir := range filepath.SplitList(os.Getenv(LibraryEnv)) {
+		if libraryDir != "" {
+			dirs = append(dirs, libraryDir)
+		}
+	}
+
+	for _, candidateDir := range dirs {
+		for _, ext := range extensions {
+			path := filepath.Join(candidateDir, filepath.FromSlash(name)+ext)
+			if _, err := os.Stat(path); err == nil {
+				return path, nil
+			} else if !errors.Is(err, fs.ErrNotExist) {
+				return "", err
+			}
+		}
+	}
+	return "", fmt.Errorf("template %q not found in %s", name, strings.Join(dirs, ", "))
+}
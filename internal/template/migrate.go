@@ -0,0 +1,247 @@
+package template
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// CurrentVersion is the schema version written by this build. Documents
+// without a version are version 1.
+//
+// Version 2 made template syntax strict: braces that form no token are
+// errors instead of text, and "\{", "\}" and "\\" are escapes. Only bodies
+// written in the original syntax are converted; see escapeLenientText.
+const CurrentVersion = 2
+
+// migrations[i] upgrades a template body from version i+1 to i+2.
+var migrations = []func(body string) string{
+	escapeLenientText,
+}
+
+// ErrVersionTooNew indicates a document written by a newer twitter-dore.
+var ErrVersionTooNew = errors.New("template version is newer than this twitter-dore supports")
+
+// version returns the schema version of the document, treating a missing
+// version as 1.
+func (d Document) version() (int, error) {
+	switch {
+	case d.Version == 0:
+		return 1, nil
+	case d.Version < 0:
+		return 0, fmt.Errorf("invalid template version %d", d.Version)
+	case d.Version > CurrentVersion:
+		return 0, fmt.Errorf("%w: version %d, supported up to %d; please update twitter-dore", ErrVersionTooNew, d.Version, CurrentVersion)
+	default:
+		return d.Version, nil
+	}
+}
+
+// Upgrade converts the document to CurrentVersion in place and returns the
+// version it was written in.
+func (d *Document) Upgrade() (int, error) {
+	from, err := d.version()
+	if err != nil {
+		return 0, err
+	}
+	for v := from; v < CurrentVersion; v++ {
+		migrate := migrations[v-1]
+		d.Template = migrate(d.Template)
+		for name, body := range d.Blocks {
+			d.Blocks[name] = migrate(body)
+		}
+	}
+	d.Version = CurrentVersion
+	return from, nil
+}
+
+// Migrate upgrades YAML document source to CurrentVersion, keeping comments
+// and the indentation of the source. The document is encoded again, so
+// quoting and blank lines may still change. Every template of a bundle is
+// upgraded. It returns the oldest version the source was written
+// in; the source is returned unchanged when it is already current.
+func Migrate(data []byte) ([]byte, int, error) {
+	roots := make([]*yaml.Node, 0, 1)
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	for {
+		var root yaml.Node
+		if err := decoder.Decode(&root); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, 0, fmt.Errorf("failed to decode template YAML: %w", err)
+		}
+		roots = append(roots, &root)
+	}
+
+	from := CurrentVersion
+	for _, root := range roots {
+		rootFrom, err := migrateRoot(root)
+		if err != nil {
+			return nil, 0, err
+		}
+		from = min(from, rootFrom)
+	}
+	if from == CurrentVersion {
+		return data, from, nil
+	}
+
+	encoded, err := encodeNodes(sourceIndent(data), roots...)
+	if err != nil {
+		return nil, 0, err
+	}
+	return encoded, from, nil
+}
+
+// migrateRoot upgrades one YAML document of a stream in place, including the
+// entries of its "templates" list, and returns the oldest version found.
+func migrateRoot(root *yaml.Node) (int, error) {
+	if len(root.Content) == 0 || root.Content[0].Kind != yaml.MappingNode {
+		return CurrentVersion, nil
+	}
+	var file bundleFile
+	if err := root.Decode(&file); err != nil {
+		return 0, fmt.Errorf("failed to decode template YAML: %w", err)
+	}
+
+	mapping := root.Content[0]
+	from, err := migrateMapping(mapping, file.Document, true)
+	if err != nil {
+		return 0, err
+	}
+
+	templates := mappingValue(mapping, "templates")
+	if templates == nil || templates.Kind != yaml.SequenceNode {
+		return from, nil
+	}
+	for i, item := range templates.Content {
+		doc := file.Templates[i]
+		// Entries without a version of their own follow the list's version,
+		// which is stamped above.
+		stamp := doc.Version != 0
+		if !stamp {
+			doc.Version = file.Version
+		}
+		itemFrom, err := migrateMapping(item, doc, stamp)
+		if err != nil {
+			return 0, err
+		}
+		from = min(from, itemFrom)
+	}
+	return from, nil
+}
+
+// migrateMapping upgrades doc and writes the changed values back to the
+// mapping it was decoded from, stamping the version when asked to.
+func migrateMapping(mapping *yaml.Node, doc Document, stamp bool) (int, error) {
+	from, err := doc.Upgrade()
+	if err != nil || from == CurrentVersion {
+		return from, err
+	}
+
+	if stamp {
+		setMappingValue(mapping, "version", &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int", Value: fmt.Sprint(CurrentVersion)})
+	}
+	if node := mappingValue(mapping, "template"); node != nil {
+		node.Value = doc.Template
+	}
+	if blocks := mappingValue(mapping, "blocks"); blocks != nil && blocks.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(blocks.Content); i += 2 {
+			blocks.Content[i+1].Value = doc.Blocks[blocks.Content[i].Value]
+		}
+	}
+	return from, nil
+}
+
+func mappingValue(mapping *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// setMappingValue replaces the value of key, or inserts the key first so that
+// the version leads the document.
+func setMappingValue(mapping *yaml.Node, key string, value *yaml.Node) {
+	if existing := mappingValue(mapping, key); existing != nil {
+		*existing = *value
+		return
+	}
+	keyNode := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}
+	if len(mapping.Content) > 0 {
+		// Keep a leading comment at the top of the document.
+		keyNode.HeadComment = mapping.Content[0].HeadComment
+		mapping.Content[0].HeadComment = ""
+	}
+	mapping.Content = append([]*yaml.Node{keyNode, value}, mapping.Content...)
+}
+
+// escapeLenientText upgrades a version 1 body. The first releases knew only
+// "{}" and "{{}}" and read every other brace and every backslash as text, so
+// a body written in that syntax has them escaped. A body that uses any later
+// syntax, such as "{名前}" or "{>footer}", was written for the strict parser
+// and is kept as is, so that its mistakes are reported instead of printed.
+func escapeLenientText(body string) string {
+	p := &parser{src: body, lineStarts: lineOffsets(body)}
+	for i := 0; i < len(body); i++ {
+		if body[i] == '{' && laterTokenWidth(p, i) > 0 {
+			return body
+		}
+	}
+
+	var builder strings.Builder
+	for i := 0; i < len(body); {
+		switch {
+		case strings.HasPrefix(body[i:], literalBraces):
+			builder.WriteString(literalBraces)
+			i += len(literalBraces)
+			continue
+		case strings.HasPrefix(body[i:], placeholderMark):
+			builder.WriteString(placeholderMark)
+			i += len(placeholderMark)
+			continue
+		case body[i] == escapeMark:
+			if i+1 < len(body) && strings.ContainsRune(escapable, rune(body[i+1])) {
+				builder.WriteString(`\\`)
+				i++
+				continue
+			}
+		case body[i] == '{':
+			builder.WriteString(`\{`)
+			i++
+			continue
+		case body[i] == '}':
+			builder.WriteString(`\}`)
+			i++
+			continue
+		}
+		builder.WriteByte(body[i])
+		i++
+	}
+	return builder.String()
+}
+
+// laterTokenWidth returns the width of the token at offset when it is syntax
+// added after the first releases: an include or block tag, or any tag the
+// parser accepts other than "{}" and "{{}}". It returns zero otherwise.
+func laterTokenWidth(p *parser, offset int) int {
+	rest := p.src[offset:]
+	if strings.HasPrefix(rest, literalBraces) || strings.HasPrefix(rest, placeholderMark) {
+		return 0
+	}
+	for _, pattern := range []*regexp.Regexp{includePattern, blockTagPattern} {
+		if loc := pattern.FindStringIndex(rest); loc != nil && loc[0] == 0 {
+			return loc[1]
+		}
+	}
+	if _, width, err := p.parseBrace(offset); err == nil {
+		return width
+	}
+	return 0
+}
@@ -0,0 +1,368 @@
+package template
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Pos locates a node within the template source.
+type Pos struct {
+	Offset int // byte offset from the start of the template
+	Line   int // 1-based line number
+	Column int // 1-based column counted in characters, not bytes
+}
+
+// Position returns the position itself so that embedding Pos satisfies Node.
+func (p Pos) Position() Pos {
+	return p
+}
+
+func (p Pos) String() string {
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
+}
+
+// Node is a single element of a parsed template body.
+type Node interface {
+	Position() Pos
+}
+
+// TextNode is template text that is copied to the output verbatim.
+type TextNode struct {
+	Pos
+	Text string
+}
+
+// LiteralNode is an escape sequence that renders as literal text: "\{", "\}"
+// and "\\" stand for the escaped character, and "{{}}" for "{}".
+type LiteralNode struct {
+	Pos
+	Source string
+	Text   string
+}
+
+// PlaceholderNode is a slot that receives an answer, written as "{}" or "{name}"
+// optionally followed by a default and a spec; see parsePlaceholderBody.
+type PlaceholderNode struct {
+	Pos
+	Source string
+	// Name is empty for anonymous "{}" placeholders.
+	Name string
+	Spec Spec
+	Attributes
+	// Filters transform the rendered answer, written "{name | upper}".
+	Filters []Filter
+	// Index refers to the Session placeholder this node is filled from.
+	Index int
+}
+
+// SectionKind distinguishes conditional sections from repeating ones.
+type SectionKind int
+
+const (
+	// SectionIf is rendered only when its controlling placeholder has a
+	// truthy answer, written "{?name}...{/}". With "{?name=value}" the
+	// answer must equal value, or include it for list kinds.
+	SectionIf SectionKind = iota
+	// SectionRepeat is rendered once per item of its list placeholder,
+	// written "{#name}...{/}"; inside it "{}" stands for the current item.
+	SectionRepeat
+)
+
+// SectionNode is a block controlled by a placeholder; see SectionKind.
+type SectionNode struct {
+	Pos
+	Source string
+	Kind   SectionKind
+	Name   string
+	Match  string
+	// HasMatch distinguishes "{?name=}" from "{?name}".
+	HasMatch bool
+	Body     []Node
+	// Index refers to the controlling Session placeholder.
+	Index int
+	// End and EndSource describe the closing "{/}" tag.
+	End       Pos
+	EndSource string
+}
+
+// ItemNode is a "{}" inside a repeating section, standing for the current item.
+type ItemNode struct {
+	Pos
+	Source  string
+	Filters []Filter
+}
+
+// endTag is the "{/}" closing a section; it never appears in a parsed tree.
+type endTag struct {
+	Pos
+	Source string
+}
+
+const (
+	escapeMark        = '\\'
+	escapable         = "{}\\"
+	literalBraces     = "{{}}"
+	placeholderMark   = "{}"
+	sectionIfMark     = '?'
+	sectionRepeatMark = '#'
+	sectionEndMark    = "/"
+)
+
+// errInvalidSection and errInvalidPlaceholder are wrapped by the errors for
+// braces that form no token at all, which older templates treated as text.
+var (
+	errInvalidSection     = errors.New("invalid section name")
+	errInvalidPlaceholder = errors.New("invalid placeholder")
+)
+
+type parser struct {
+	src        string
+	lineStarts []int
+	off        int
+	// repeats counts the repeating sections enclosing the current position.
+	repeats int
+}
+
+func parse(src string) ([]Node, error) {
+	p := &parser{
+		src:        src,
+		lineStarts: lineOffsets(src),
+	}
+
+	nodes, end, err := p.parseNodes()
+	if err != nil {
+		return nil, err
+	}
+	if end != nil {
+		return nil, p.errorf(end.Offset, "%s does not close any section", end.Source)
+	}
+	return nodes, nil
+}
+
+// parseNodes reads nodes until the end of the input or a section's closing
+// tag, which is returned so that the caller can finish the section.
+func (p *parser) parseNodes() ([]Node, *endTag, error) {
+	nodes := make([]Node, 0)
+	textStart := p.off
+	for p.off < len(p.src) {
+		start := p.off
+		var (
+			node  Node
+			width int
+			err   error
+		)
+		switch p.src[p.off] {
+		case escapeMark:
+			node, width = p.parseEscape(start)
+		case '{':
+			node, width, err = p.parseBrace(start)
+		case '}':
+			err = p.errorf(p.off, "unmatched } (write \\} for a literal brace)")
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if node == nil {
+			p.off++
+			continue
+		}
+
+		nodes = p.appendText(nodes, textStart, start)
+		p.off += width
+
+		switch n := node.(type) {
+		case *endTag:
+			return nodes, n, nil
+		case *SectionNode:
+			if n.Kind == SectionRepeat {
+				p.repeats++
+			}
+			body, end, err := p.parseNodes()
+			if n.Kind == SectionRepeat {
+				p.repeats--
+			}
+			if err != nil {
+				return nil, nil, err
+			}
+			if end == nil {
+				return nil, nil, p.errorf(n.Offset, "section %s is not closed with {/}", n.Source)
+			}
+			n.Body = body
+			n.End = end.Pos
+			n.EndSource = end.Source
+		}
+
+		nodes = append(nodes, node)
+		textStart = p.off
+	}
+
+	return p.appendText(nodes, textStart, len(p.src)), nil, nil
+}
+
+// parseEscape recognises a backslash followed by a brace or another
+// backslash. Any other backslash is ordinary text and yields a nil node.
+func (p *parser) parseEscape(offset int) (Node, int) {
+	if offset+1 >= len(p.src) || !strings.ContainsRune(escapable, rune(p.src[offset+1])) {
+		return nil, 0
+	}
+	source := p.src[offset : offset+2]
+	return &LiteralNode{Pos: p.pos(offset), Source: source, Text: source[1:]}, len(source)
+}
+
+// parseBrace recognises the token starting at offset and returns it with its
+// width in bytes. Braces that do not form a valid token are errors; literal
+// braces are written "\{" and "\}", or "{{}}" for the pair.
+func (p *parser) parseBrace(offset int) (Node, int, error) {
+	rest := p.src[offset:]
+	if strings.HasPrefix(rest, literalBraces) {
+		return &LiteralNode{Pos: p.pos(offset), Source: literalBraces, Text: placeholderMark}, len(literalBraces), nil
+	}
+
+	end := tokenEnd(rest)
+	switch {
+	case end < 0:
+		return nil, 0, p.errorf(offset, "{ is not closed with } on the same line (write \\{ for a literal brace)")
+	case rest[end] == '{':
+		return nil, 0, p.errorf(offset+end, "{ cannot appear inside a placeholder")
+	}
+
+	source := rest[:end+1]
+	body := rest[1:end]
+	pos := p.pos(offset)
+
+	switch {
+	case body == sectionEndMark:
+		return &endTag{Pos: pos, Source: source}, len(source), nil
+	case body != "" && body[0] == sectionIfMark:
+		name, match, hasMatch := strings.Cut(body[1:], "=")
+		if !isName(name) {
+			return nil, 0, p.errorf(offset, "%w in %s", errInvalidSection, source)
+		}
+		return &SectionNode{Pos: pos, Source: source, Kind: SectionIf, Name: name, Match: match, HasMatch: hasMatch}, len(source), nil
+	case body != "" && body[0] == sectionRepeatMark:
+		if !isName(body[1:]) {
+			return nil, 0, p.errorf(offset, "%w in %s", errInvalidSection, source)
+		}
+		return &SectionNode{Pos: pos, Source: source, Kind: SectionRepeat, Name: body[1:]}, len(source), nil
+	}
+
+	unquoted, attrs, duplicate, ok := cutAttributes(body)
+	if !ok {
+		return nil, 0, p.errorf(offset, "attribute %q is given more than once in %s", duplicate, source)
+	}
+	head, pipeline, hasPipeline := cutPipeline(unquoted)
+	if hasPipeline {
+		head = strings.TrimRight(head, " ")
+	}
+
+	var node Node
+	if head == "" && p.repeats > 0 {
+		if attrs != (Attributes{}) {
+			return nil, 0, p.errorf(offset, "list item %s cannot have attributes", source)
+		}
+		node = &ItemNode{Pos: pos, Source: source}
+	} else if src, ok := strings.CutPrefix(head, exprMark); ok {
+		expr, err := parseExpr(src)
+		if err != nil {
+			var exprErr *exprError
+			errors.As(err, &exprErr)
+			// The expression starts after the brace and the mark.
+			return nil, 0, p.errorf(offset+1+len(exprMark)+exprErr.offset, "expression: %s", exprErr.msg)
+		}
+		name := exprMark + " " + strings.TrimSpace(src)
+		node = &PlaceholderNode{Pos: pos, Source: source, Name: name, Spec: Spec{Kind: KindComputed, Expr: expr}, Attributes: attrs}
+	} else {
+		name, spec, ok := parsePlaceholderBody(head)
+		if !ok {
+			return nil, 0, p.errorf(offset, "%w %s", errInvalidPlaceholder, source)
+		}
+		node = &PlaceholderNode{Pos: pos, Source: source, Name: name, Spec: spec, Attributes: attrs}
+	}
+
+	if hasPipeline {
+		// The pipeline starts after the brace, the head and the separator.
+		filters, err := p.parseFilters(offset+1+len(body)-len(pipeline), pipeline)
+		if err != nil {
+			return nil, 0, err
+		}
+		switch n := node.(type) {
+		case *ItemNode:
+			n.Filters = filters
+		case *PlaceholderNode:
+			n.Filters = filters
+		}
+	}
+
+	return node, len(source), nil
+}
+
+// tokenEnd returns the index of the brace closing the token at the start of
+// s, the index of a nested "{" that interrupts it, or -1 when the token is not
+// closed on the same line. Braces inside the string literals of an expression
+// belong to the string.
+func tokenEnd(s string) int {
+	isExpr := strings.HasPrefix(s[1:], exprMark)
+	var quote byte
+	for i := 1; i < len(s); i++ {
+		switch {
+		case s[i] == '\n':
+			return -1
+		case quote != 0:
+			if s[i] == quote {
+				quote = 0
+			}
+		case isExpr && (s[i] == '"' || s[i] == '\''):
+			quote = s[i]
+		case s[i] == '}' || s[i] == '{':
+			return i
+		}
+	}
+	return -1
+}
+
+// nameReserved lists characters that cannot appear in placeholder names because
+// they are, or may become, template syntax.
+const nameReserved = "{}:=|\"\\?#/>@%!&*+.$,;()[]<'`~^"
+
+func isName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if unicode.IsSpace(r) || strings.ContainsRune(nameReserved, r) {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *parser) appendText(nodes []Node, start, end int) []Node {
+	if start >= end {
+		return nodes
+	}
+	return append(nodes, &TextNode{Pos: p.pos(start), Text: p.src[start:end]})
+}
+
+func (p *parser) pos(offset int) Pos {
+	line := sort.Search(len(p.lineStarts), func(i int) bool { return p.lineStarts[i] > offset }) - 1
+	lineStart := p.lineStarts[line]
+	return Pos{
+		Offset: offset,
+		Line:   line + 1,
+		Column: utf8.RuneCountInString(p.src[lineStart:offset]) + 1,
+	}
+}
+
+func lineOffsets(src string) []int {
+	offsets := []int{0}
+	for i := 0; i < len(src); i++ {
+		if src[i] == '\n' {
+			offsets = append(offsets, i+1)
+		}
+	}
+	return offsets
+}
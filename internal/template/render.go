@@ -0,0 +1,224 @@
+package template
+
+import (
+	"strings"
+)
+
+// falsyAnswers are text answers that hide a "{?name}" section in addition to
+// an empty answer. They are compared case-insensitively.
+var falsyAnswers = map[string]bool{
+	"false": true,
+	"no":    true,
+	"n":     true,
+	"off":   true,
+	"0":     true,
+	"いいえ":   true,
+	"なし":    true,
+	"無し":    true,
+}
+
+// renderer writes the filled template line by line so that lines emptied by
+// hidden sections, holding nothing but section tags, or whose placeholders
+// are all optional and unanswered, can be dropped.
+type renderer struct {
+	session *Session
+	values  []Value
+	out     strings.Builder
+	line    strings.Builder
+	// lineHasTag marks that the current line contains a section tag.
+	lineHasTag bool
+	// lineHasOptional marks that the current line contains an optional
+	// placeholder that was left empty, and lineHasAnswer that it contains an
+	// answer or a placeholder that is not optional.
+	lineHasOptional bool
+	lineHasAnswer   bool
+	// items holds the current item of each enclosing repeating section.
+	items []string
+}
+
+func (r *renderer) run(nodes []Node) string {
+	r.render(nodes)
+	if !r.endLine(false) {
+		// The last line vanished, so the newline before it is now trailing.
+		return strings.TrimSuffix(r.out.String(), "\n")
+	}
+	return r.out.String()
+}
+
+func (r *renderer) render(nodes []Node) {
+	for _, node := range nodes {
+		switch n := node.(type) {
+		case *TextNode:
+			r.writeText(n.Text)
+		case *LiteralNode:
+			r.writeText(n.Text)
+		case *PlaceholderNode:
+			placeholder := r.session.placeholders[n.Index]
+			value := r.values[n.Index]
+			if value.IsEmpty() && (placeholder.Optional || r.session.optional) {
+				r.lineHasOptional = true
+			} else {
+				r.lineHasAnswer = true
+			}
+			r.line.WriteString(applyFilters(n.Filters, r.session.renderValue(placeholder, value)))
+		case *ItemNode:
+			if len(r.items) > 0 {
+				r.lineHasAnswer = true
+				r.line.WriteString(applyFilters(n.Filters, r.items[len(r.items)-1]))
+			}
+		case *SectionNode:
+			r.lineHasTag = true
+			r.renderSection(n)
+			r.lineHasTag = true
+		}
+	}
+}
+
+func (r *renderer) renderSection(section *SectionNode) {
+	value := r.values[section.Index]
+	if section.Kind == SectionIf {
+		if r.session.sectionVisible(section, value) {
+			r.render(section.Body)
+		}
+		return
+	}
+
+	for _, item := range value.Items {
+		r.items = append(r.items, item)
+		r.render(section.Body)
+		r.items = r.items[:len(r.items)-1]
+	}
+}
+
+// writeText copies template text, finishing a line at every newline. Answers
+// are written to the line directly, so their newlines never split a line.
+func (r *renderer) writeText(text string) {
+	for {
+		idx := strings.IndexByte(text, '\n')
+		if idx < 0 {
+			r.line.WriteString(text)
+			return
+		}
+		r.line.WriteString(text[:idx])
+		r.endLine(true)
+		text = text[idx+1:]
+	}
+}
+
+// endLine flushes the current line and reports whether it was kept.
+func (r *renderer) endLine(newline bool) bool {
+	line := r.line.String()
+	hasTag := r.lineHasTag
+	emptied := r.lineHasOptional && !r.lineHasAnswer
+	r.line.Reset()
+	r.lineHasTag, r.lineHasOptional, r.lineHasAnswer = false, false, false
+
+	if hasTag && strings.TrimSpace(line) == "" || emptied {
+		return false
+	}
+
+	r.out.WriteString(line)
+	if newline {
+		r.out.WriteByte('\n')
+	}
+	return true
+}
+
+// sectionVisible reports whether a section's body is rendered for the answer
+// to its controlling placeholder.
+func (s *Session) sectionVisible(section *SectionNode, value Value) bool {
+	if section.Kind == SectionRepeat {
+		return len(value.Items) > 0
+	}
+
+	if section.HasMatch {
+		if value.Items != nil {
+			for _, item := range value.Items {
+				if item == section.Match {
+					return true
+				}
+			}
+			return false
+		}
+		return strings.TrimSpace(value.Text) == section.Match
+	}
+
+	if value.Items != nil {
+		return len(value.Items) > 0
+	}
+	text := strings.TrimSpace(value.Text)
+	return text != "" && !falsyAnswers[strings.ToLower(text)]
+}
+
+// renderValue formats an answer according to the placeholder's kind.
+func (s *Session) renderValue(placeholder Placeholder, value Value) string {
+	switch {
+	case placeholder.Kind.IsScale() && strings.TrimSpace(value.Text) != "":
+		return placeholder.renderScale(value.Text)
+	case placeholder.Kind == KindHandle && value.Text != "" && !strings.HasPrefix(value.Text, handlePrefix):
+		return handlePrefix + value.Text
+	case placeholder.Kind != KindCheck:
+		return value.Text
+	}
+
+	selected := make(map[string]bool, len(value.Items))
+	for _, item := range value.Items {
+		selected[item] = true
+	}
+
+	options := make([]string, len(placeholder.Choices))
+	for i, choice := range placeholder.Choices {
+		marker := s.markers.Unchecked
+		if selected[choice] {
+			marker = s.markers.Checked
+		}
+		options[i] = marker + choice
+	}
+	return strings.Join(options, " ")
+}
+
+// HighlightPreview returns the template with placeholders and section tags
+// visually highlighted.
+func HighlightPreview(raw string, highlight func(string) string) string {
+	if highlight == nil {
+		return raw
+	}
+
+	nodes, err := parse(raw)
+	if err != nil {
+		return raw
+	}
+
+	var builder strings.Builder
+	writeSource(&builder, nodes, highlight)
+	return builder.String()
+}
+
+// displayLines renders the template source line by line with literals resolved
+// and tags left in their source form, for use as prompt context.
+func displayLines(nodes []Node) []string {
+	var builder strings.Builder
+	writeSource(&builder, nodes, func(source string) string { return source })
+	return strings.Split(builder.String(), "\n")
+}
+
+// writeSource writes the template back in source order, resolving literals and
+// passing the source of every tag through decorate.
+func writeSource(builder *strings.Builder, nodes []Node, decorate func(string) string) {
+	for _, node := range nodes {
+		switch n := node.(type) {
+		case *TextNode:
+			builder.WriteString(n.Text)
+		case *LiteralNode:
+			builder.WriteString(n.Text)
+		case *PlaceholderNode:
+			builder.WriteString(decorate(n.Source))
+		case *ItemNode:
+			builder.WriteString(decorate(n.Source))
+		case *SectionNode:
+			builder.WriteString(decorate(n.Source))
+			writeSource(builder, n.Body, decorate)
+			builder.WriteString(decorate(n.EndSource))
+		}
+	}
+}
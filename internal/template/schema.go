@@ -0,0 +1,127 @@
+package template
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// schemaDialect is the JSON Schema version JSONSchema conforms to.
+const schemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// schemaEnumerator is implemented by string types whose values are limited
+// to a known set.
+type schemaEnumerator interface {
+	schemaEnum() []string
+}
+
+func (Kind) schemaEnum() []string {
+	values := make([]string, len(declarableKinds))
+	for i, kind := range declarableKinds {
+		values[i] = string(kind)
+	}
+	return values
+}
+
+// JSONSchema describes the YAML format of a template file as a JSON Schema,
+// generated from Document and its yaml tags so that it cannot fall out of
+// date. Unknown keys are rejected, as the lint command does. A stream of
+// several documents is validated one document at a time.
+func JSONSchema() ([]byte, error) {
+	g := &schemaGenerator{defs: make(map[string]any)}
+	root := g.objectSchema(reflect.TypeOf(bundleFile{}))
+	root["$schema"] = schemaDialect
+	root["title"] = "twitter-dore template"
+	root["$defs"] = g.defs
+
+	data, err := json.MarshalIndent(root, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal JSON schema: %w", err)
+	}
+	return append(data, '\n'), nil
+}
+
+// schemaGenerator maps Go types to schemas, collecting named structs in defs.
+type schemaGenerator struct {
+	defs map[string]any
+}
+
+func (g *schemaGenerator) typeSchema(t reflect.Type) map[string]any {
+	if enumerator, ok := reflect.Zero(t).Interface().(schemaEnumerator); ok {
+		return map[string]any{"type": "string", "enum": enumerator.schemaEnum()}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return g.typeSchema(t.Elem())
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": g.typeSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": g.typeSchema(t.Elem())}
+	case reflect.Struct:
+		if _, ok := g.defs[t.Name()]; !ok {
+			// Reserve the name first so that recursive types terminate.
+			g.defs[t.Name()] = nil
+			g.defs[t.Name()] = g.objectSchema(t)
+		}
+		return map[string]any{"$ref": "#/$defs/" + t.Name()}
+	default:
+		return map[string]any{}
+	}
+}
+
+// objectSchema lists the exported fields of a struct under their yaml keys,
+// flattening inline fields as yaml.v3 does.
+func (g *schemaGenerator) objectSchema(t reflect.Type) map[string]any {
+	properties := make(map[string]any)
+	g.addProperties(t, properties)
+	return map[string]any{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+}
+
+func (g *schemaGenerator) addProperties(t reflect.Type, properties map[string]any) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, options, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if name == "-" {
+			continue
+		}
+		if strings.Contains(","+options+",", ",inline,") {
+			g.addProperties(field.Type, properties)
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		if field.Tag.Get("schema") == "scalar" {
+			properties[name] = scalarSchema(field.Type)
+			continue
+		}
+		properties[name] = g.typeSchema(field.Type)
+	}
+}
+
+// scalarSchema accepts any YAML scalar where a string, or a list of strings,
+// is expected: yaml.v3 decodes 3 or true into a string as written.
+func scalarSchema(t reflect.Type) map[string]any {
+	if t.Kind() == reflect.Slice {
+		return map[string]any{"type": "array", "items": scalarSchema(t.Elem())}
+	}
+	return map[string]any{"type": []string{"string", "number", "boolean"}}
+}
@@ -5,135 +5,267 @@ import (
 	"strings"
 )
 
-// Placeholder describes a detected "{}" token in the template body.
+// Placeholder describes a value slot in the template body. Anonymous "{}"
+// tokens each get their own Placeholder, while every "{name}" sharing the same
+// name is collapsed into one Placeholder that is asked for once.
 type Placeholder struct {
 	Index int
+	// Name is empty for anonymous placeholders.
+	Name string
+	// Label is the prompt label: the label attribute or field when set,
+	// otherwise the name, or the text before an anonymous placeholder.
 	Label string
-	Line  string
+	// Hint and Example are optional help shown with the prompt.
+	Hint    string
+	Example string
+	Spec
+	// Line and Pos describe the first occurrence.
+	Line        string
+	Pos         Pos
+	Occurrences []Occurrence
+	// labeled is set once an explicit label attribute or field has been
+	// applied.
+	labeled bool
+	// unlabeled is set for an anonymous placeholder with no text before it,
+	// whose label falls back to "fieldN".
+	unlabeled bool
+}
+
+// Occurrence records where a placeholder appears in the template body.
+type Occurrence struct {
+	Pos  Pos
+	Line string
+	// guards are the sections enclosing the occurrence, outermost first.
+	guards []*SectionNode
 }
 
 // Session represents a prepared template ready to be filled.
 type Session struct {
-	sanitized    string
+	nodes        []Node
 	placeholders []Placeholder
-	protector    literalProtector
+	markers      Markers
+	env          Environment
+	// optional makes every placeholder optional, as Document.Optional.
+	optional bool
 }
 
-// NewSession prepares the template body for interactive filling.
+// NewSession parses the template body and prepares it for interactive filling.
 func NewSession(raw string) (*Session, error) {
-	sanitized, protector := protectLiterals(raw)
-	placeholders := extractPlaceholders(sanitized, protector)
+	nodes, err := parse(raw)
+	if err != nil {
+		return nil, err
+	}
 
 	return &Session{
-		sanitized:    sanitized,
-		placeholders: placeholders,
-		protector:    protector,
+		nodes:        nodes,
+		placeholders: extractPlaceholders(nodes),
+		markers:      Markers{}.withDefaults(),
+		env:          defaultEnvironment(),
 	}, nil
 }
 
+// ApplyFields overrides placeholder metadata with the declarations from a
+// document's fields section. Anonymous placeholders cannot be declared.
+func (s *Session) ApplyFields(fields map[string]Field) error {
+	for i := range s.placeholders {
+		placeholder := &s.placeholders[i]
+		field, ok := fields[placeholder.Name]
+		if !ok || placeholder.Name == "" {
+			continue
+		}
+		if field.Label != "" {
+			placeholder.Label = field.Label
+			placeholder.labeled = true
+		}
+		if field.Hint != "" {
+			placeholder.Hint = field.Hint
+		}
+		if field.Example != "" {
+			placeholder.Example = field.Example
+		}
+		if err := placeholder.apply(field); err != nil {
+			return fmt.Errorf("field %q: %w", placeholder.Name, err)
+		}
+	}
+	return nil
+}
+
 // Placeholders returns a copy of the detected placeholders.
 func (s *Session) Placeholders() []Placeholder {
 	result := make([]Placeholder, len(s.placeholders))
-	copy(result, s.placeholders)
+	for i, placeholder := range s.placeholders {
+		placeholder.Occurrences = append([]Occurrence(nil), placeholder.Occurrences...)
+		placeholder.Choices = append([]string(nil), placeholder.Choices...)
+		result[i] = placeholder
+	}
 	return result
 }
 
-// Fill applies the supplied values to the template in order.
-func (s *Session) Fill(values []string) (string, error) {
+// Fill renders the template with the supplied values in placeholder order.
+// Values are inserted as-is and never interpreted as template syntax. The
+// values of computed placeholders are ignored and computed instead.
+func (s *Session) Fill(values []Value) (string, error) {
 	if len(values) != len(s.placeholders) {
 		return "", fmt.Errorf("expected %d values but received %d", len(s.placeholders), len(values))
 	}
 
-	result := s.sanitized
-	for _, value := range values {
-		result = strings.Replace(result, "{}", value, 1)
+	values, err := s.computeValues(values)
+	if err != nil {
+		return "", err
 	}
 
-	return s.protector.Restore(result), nil
+	r := &renderer{session: s, values: values}
+	return r.run(s.nodes), nil
 }
 
-// HighlightPreview returns the template with placeholders visually highlighted.
-func HighlightPreview(raw string, highlight func(string) string) string {
-	if highlight == nil {
-		return raw
+// Relevant reports whether the placeholder at index can still affect the
+// output, given the answers to the placeholders before it. It is false when
+// every occurrence sits inside a section that those answers already hide, so
+// the placeholder need not be asked.
+func (s *Session) Relevant(index int, values []Value) bool {
+	for _, occurrence := range s.placeholders[index].Occurrences {
+		if s.occurrenceVisible(occurrence, index, values) {
+			return true
+		}
 	}
-
-	sanitized, protector := protectLiterals(raw)
-	highlighted := strings.ReplaceAll(sanitized, "{}", highlight("{}"))
-	return protector.Restore(highlighted)
-}
-
-type literalProtector struct {
-	tokens []string
+	return false
 }
 
-func protectLiterals(input string) (string, literalProtector) {
-	var builder strings.Builder
-	var tokens []string
-
-	for i := 0; i < len(input); {
-		if strings.HasPrefix(input[i:], "{{}}") {
-			token := fmt.Sprintf("__TWITTER_DORE_LITERAL_%d__", len(tokens))
-			tokens = append(tokens, token)
-			builder.WriteString(token)
-			i += len("{{}}")
+func (s *Session) occurrenceVisible(occurrence Occurrence, index int, values []Value) bool {
+	for _, guard := range occurrence.guards {
+		if guard.Index >= index || guard.Index >= len(values) {
 			continue
 		}
+		if !s.sectionVisible(guard, values[guard.Index]) {
+			return false
+		}
+	}
+	return true
+}
 
-		builder.WriteByte(input[i])
-		i++
+// extractPlaceholders numbers the placeholder nodes, merging named ones, and
+// infers a label for anonymous ones from the text preceding them on the line.
+func extractPlaceholders(nodes []Node) []Placeholder {
+	e := &extractor{
+		lines:        displayLines(nodes),
+		placeholders: make([]Placeholder, 0),
+		byName:       make(map[string]int),
+		fieldCounter: 1,
 	}
+	e.walk(nodes)
+	return e.placeholders
+}
 
-	return builder.String(), literalProtector{tokens: tokens}
+type extractor struct {
+	lines        []string
+	placeholders []Placeholder
+	byName       map[string]int
+	fieldCounter int
+	// segment collects the text since the previous tag on the current line.
+	segment strings.Builder
+	// guards are the sections enclosing the current node, outermost first.
+	guards []*SectionNode
 }
 
-func (p literalProtector) Restore(value string) string {
-	if len(p.tokens) == 0 {
-		return value
+func (e *extractor) walk(nodes []Node) {
+	for _, node := range nodes {
+		switch n := node.(type) {
+		case *TextNode:
+			text := n.Text
+			if idx := strings.LastIndexByte(text, '\n'); idx >= 0 {
+				e.segment.Reset()
+				text = text[idx+1:]
+			}
+			e.segment.WriteString(text)
+		case *LiteralNode:
+			e.segment.WriteString(n.Text)
+		case *PlaceholderNode:
+			label := strings.TrimSpace(e.segment.String())
+			e.segment.Reset()
+			unlabeled := label == ""
+			if unlabeled {
+				label = fmt.Sprintf("field%d", e.fieldCounter)
+			}
+			e.fieldCounter++
+			if n.Spec.Expr != nil {
+				e.addReferences(n.Spec.Expr, n.Pos)
+			}
+			n.Index = e.add(n.Name, label, n.Spec, n.Pos)
+			if n.Name == "" {
+				e.placeholders[n.Index].unlabeled = unlabeled
+			}
+			e.placeholders[n.Index].setAttributes(n.Attributes)
+		case *ItemNode:
+			e.segment.Reset()
+		case *SectionNode:
+			e.segment.Reset()
+			kind := KindText
+			if n.Kind == SectionRepeat {
+				kind = KindList
+			}
+			n.Index = e.add(n.Name, n.Name, Spec{Kind: kind}, n.Pos)
+			e.guards = append(e.guards, n)
+			e.walk(n.Body)
+			e.guards = e.guards[:len(e.guards)-1]
+			e.segment.Reset()
+		}
 	}
+}
 
-	result := value
-	for _, token := range p.tokens {
-		result = strings.ReplaceAll(result, token, "{}")
+// addReferences makes sure that every name an expression reads is asked for,
+// even when it appears nowhere else in the template.
+func (e *extractor) addReferences(expr *Expr, pos Pos) {
+	for _, name := range expr.Names() {
+		if _, ok := e.byName[name]; !ok {
+			e.add(name, name, Spec{Kind: KindText}, pos)
+		}
 	}
-
-	return result
 }
 
-func extractPlaceholders(input string, protector literalProtector) []Placeholder {
-	lines := strings.Split(input, "\n")
-	placeholders := make([]Placeholder, 0)
-
-	fieldCounter := 1
-	for _, line := range lines {
-		displayLine := protector.Restore(line)
-		offset := 0
-		segmentStart := 0
-
-		for {
-			idx := strings.Index(line[offset:], "{}")
-			if idx < 0 {
-				break
-			}
+// setAttributes applies the attributes of an occurrence. An explicit label
+// replaces the inferred one, but the first occurrence that sets an attribute
+// wins.
+func (p *Placeholder) setAttributes(attrs Attributes) {
+	if attrs.Label != "" && !p.labeled {
+		p.Label = attrs.Label
+		p.labeled = true
+	}
+	if p.Hint == "" {
+		p.Hint = attrs.Hint
+	}
+	if p.Example == "" {
+		p.Example = attrs.Example
+	}
+}
 
-			absolute := offset + idx
-			labelSegment := line[segmentStart:absolute]
-			labelText := strings.TrimSpace(protector.Restore(labelSegment))
-			if labelText == "" {
-				labelText = fmt.Sprintf("field%d", fieldCounter)
-			}
+// add records an occurrence and returns the index of its placeholder. Named
+// occurrences are merged into the first placeholder with the same name.
+func (e *extractor) add(name, label string, spec Spec, pos Pos) int {
+	occurrence := Occurrence{
+		Pos:    pos,
+		Line:   e.lines[pos.Line-1],
+		guards: append([]*SectionNode(nil), e.guards...),
+	}
 
-			placeholders = append(placeholders, Placeholder{
-				Index: len(placeholders),
-				Label: labelText,
-				Line:  displayLine,
-			})
+	if idx, ok := e.byName[name]; ok && name != "" {
+		e.placeholders[idx].merge(spec)
+		e.placeholders[idx].Occurrences = append(e.placeholders[idx].Occurrences, occurrence)
+		return idx
+	}
 
-			fieldCounter++
-			offset = absolute + len("{}")
-			segmentStart = offset
-		}
+	if name != "" {
+		label = name
+		e.byName[name] = len(e.placeholders)
 	}
 
-	return placeholders
+	e.placeholders = append(e.placeholders, Placeholder{
+		Index:       len(e.placeholders),
+		Name:        name,
+		Label:       label,
+		Spec:        spec,
+		Line:        occurrence.Line,
+		Pos:         pos,
+		Occurrences: []Occurrence{occurrence},
+	})
+	return len(e.placeholders) - 1
 }
@@ -0,0 +1,435 @@
+package template
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Kind selects how a placeholder is asked for and rendered.
+type Kind string
+
+const (
+	// KindText is a free-text answer. It is the default kind.
+	KindText Kind = "text"
+	// KindChoice restricts the answer to one of Spec.Choices.
+	KindChoice Kind = "choice"
+	// KindCheck selects any number of Spec.Choices and renders every option
+	// with a checked or unchecked marker.
+	KindCheck Kind = "check"
+	// KindStars is an integer rendered as a star rating such as "★★★☆☆".
+	KindStars Kind = "stars"
+	// KindGauge is an integer rendered as a block gauge such as "■■■□□".
+	KindGauge Kind = "gauge"
+	// KindPercent is an integer rendered as its percentage of the range.
+	KindPercent Kind = "percent"
+	// KindInt accepts an integer.
+	KindInt Kind = "int"
+	// KindNumber accepts any decimal number.
+	KindNumber Kind = "number"
+	// KindDate accepts a date such as "2006-01-02", "2006/1/2" or "1月2日".
+	KindDate Kind = "date"
+	// KindHandle accepts a Twitter handle and renders it with a leading "@".
+	KindHandle Kind = "handle"
+	// KindURL accepts an absolute http or https URL.
+	KindURL Kind = "url"
+	// KindList collects any number of free-text items for a repeating section.
+	KindList Kind = "list"
+	// KindMultiline is free text that may span several lines.
+	KindMultiline Kind = "multiline"
+	// KindComputed is filled without prompting by one of the builtins.
+	KindComputed Kind = "computed"
+)
+
+// declarableKinds are the kinds a field declaration may set. Computed
+// placeholders need the inline syntax to say what they compute.
+var declarableKinds = []Kind{
+	KindText, KindChoice, KindCheck, KindStars, KindGauge, KindPercent,
+	KindInt, KindNumber, KindDate, KindHandle, KindURL, KindList, KindMultiline,
+}
+
+// IsComputed reports whether answers of this kind are filled without prompting.
+func (k Kind) IsComputed() bool {
+	return k == KindComputed
+}
+
+// IsTyped reports whether the kind is free text checked against a type.
+func (k Kind) IsTyped() bool {
+	switch k {
+	case KindInt, KindNumber, KindDate, KindHandle, KindURL:
+		return true
+	default:
+		return false
+	}
+}
+
+// IsList reports whether answers of this kind are a list of items.
+func (k Kind) IsList() bool {
+	return k == KindCheck || k == KindList
+}
+
+// IsScale reports whether answers of this kind are integers within a range.
+func (k Kind) IsScale() bool {
+	return k == KindStars || k == KindGauge || k == KindPercent
+}
+
+// scaleDefaults holds the range and symbols used when a scale spec omits them.
+var scaleDefaults = map[Kind]Spec{
+	KindStars:   {Min: 0, Max: 5, Filled: "★", Empty: "☆"},
+	KindGauge:   {Min: 0, Max: 10, Filled: "■", Empty: "□"},
+	KindPercent: {Min: 0, Max: 100},
+}
+
+// Spec is the declared behaviour of a placeholder, gathered from the inline
+// syntax and the document's fields section.
+type Spec struct {
+	Kind Kind
+	// Default is used when the answer is left empty. List kinds separate
+	// items with commas.
+	Default string
+	// Choices lists the allowed answers for KindChoice and KindCheck.
+	Choices []string
+	// Min and Max bound the answers of scale kinds, inclusive.
+	Min int
+	Max int
+	// Filled and Empty are the symbols drawn by KindStars and KindGauge.
+	Filled string
+	Empty  string
+	// Pattern, MinLength and MaxLength constrain text answers. Lengths count
+	// characters and zero means unlimited. Message, when set, replaces the
+	// error reported for a violated constraint.
+	Pattern   *regexp.Regexp
+	MinLength int
+	MaxLength int
+	Message   string
+	// Optional lets the renderer drop a line on which the placeholder was
+	// left empty, provided every other placeholder on the line is optional
+	// and empty too. It is written "{name?}".
+	Optional bool
+	// Required rejects an empty answer even when empty answers are otherwise
+	// allowed. It cannot be combined with Optional.
+	Required bool
+	// Builtin and Args select the computation of KindComputed, written
+	// "{@builtin:args}". Expr is set instead for "{= expression}".
+	Builtin string
+	Args    string
+	Expr    *Expr
+}
+
+const (
+	checkKeyword   = "check"
+	rangeSeparator = ".."
+	handlePrefix   = "@"
+	optionalMark   = "?"
+)
+
+// parsePlaceholderBody splits the text between the braces of a placeholder
+// token into its name and spec. The accepted forms are:
+//
+//	{}                        anonymous text
+//	{name}                    named text
+//	{name=default}            with a default value
+//	{name: a|b|c}             choice between two or more options
+//	{name: check a|b|c}       checklist of options, any number may be selected
+//	{name: stars 0..5 ★☆}     star rating; range and symbols are optional
+//	{name: gauge 0..10 ■□}    block gauge; range and symbols are optional
+//	{name: percent 0..10}     percentage of the range; the range is optional
+//	{name:int}                typed text: int, number, date, handle or url
+//	{name:multiline}          free text spanning several lines
+//	{name?}                   optional; see Spec.Optional
+//	{@name}                   shorthand for {name:handle}
+//	{@today:2006/01/02}       computed without prompting; see builtins
+//
+// Expressions such as "{= 好感度 * 10}" are handled by parseExpr.
+//
+// A bare name that is itself a type, such as "{url}", has that type. The
+// default may be combined with a spec, as in "{name=b: a|b|c}".
+func parsePlaceholderBody(body string) (string, Spec, bool) {
+	if spec, ok, isBuiltin := parseBuiltin(body); isBuiltin {
+		// Computed placeholders are named by their whole body so that the
+		// same builtin with different arguments yields separate values.
+		return body, spec, ok
+	}
+
+	head, rest, hasSpec := cutSpec(body)
+	name, defaultValue, hasDefault := strings.Cut(head, "=")
+	if hasSpec && !hasDefault {
+		name = strings.TrimRight(name, " ")
+	}
+
+	spec := Spec{Kind: KindText}
+	name, optional := strings.CutSuffix(name, optionalMark)
+	if handle, ok := strings.CutPrefix(name, handlePrefix); ok {
+		name = handle
+		spec.Kind = KindHandle
+	} else if Kind(name).IsTyped() {
+		spec.Kind = Kind(name)
+	}
+
+	if body != "" && !isName(name) {
+		return "", Spec{}, false
+	}
+
+	if hasSpec {
+		var ok bool
+		if spec, ok = parseSpec(strings.TrimSpace(rest)); !ok {
+			return "", Spec{}, false
+		}
+	}
+	spec.Default = defaultValue
+	spec.Optional = optional
+
+	return name, spec, true
+}
+
+// cutSpec splits a placeholder body at the colon that starts its spec. A
+// colon after "=" belongs to the default unless a spec follows it, so that
+// "{site=https://example.com}" and "{t=12:00}" keep their defaults whole.
+func cutSpec(body string) (string, string, bool) {
+	i := strings.IndexAny(body, "=:")
+	if i < 0 || body[i] == ':' {
+		return strings.Cut(body, ":")
+	}
+	for {
+		next := strings.IndexByte(body[i+1:], ':')
+		if next < 0 {
+			return body, "", false
+		}
+		i += 1 + next
+		if _, ok := parseSpec(strings.TrimSpace(body[i+1:])); ok {
+			return body[:i], body[i+1:], true
+		}
+	}
+}
+
+// parseSpec interprets the part of a placeholder after the colon.
+func parseSpec(value string) (Spec, bool) {
+	keyword, args, _ := strings.Cut(value, " ")
+	kind := Kind(keyword)
+
+	switch {
+	case (kind.IsTyped() || kind == KindMultiline) && args == "":
+		return Spec{Kind: kind}, true
+	case kind == checkKeyword:
+		choices, ok := parseChoices(args, 1)
+		return Spec{Kind: KindCheck, Choices: choices}, ok
+	case kind.IsScale():
+		return parseScale(kind, strings.Fields(args))
+	default:
+		choices, ok := parseChoices(value, 2)
+		return Spec{Kind: KindChoice, Choices: choices}, ok
+	}
+}
+
+// parseScale reads the optional "min..max" range and symbols of a scale spec.
+// Symbols are given either as one two-character token ("■□") or as two
+// separate tokens for the filled and empty states.
+func parseScale(kind Kind, args []string) (Spec, bool) {
+	spec := scaleDefaults[kind]
+	spec.Kind = kind
+
+	if len(args) > 0 && strings.Contains(args[0], rangeSeparator) {
+		minValue, maxValue, ok := parseRange(args[0])
+		if !ok {
+			return Spec{}, false
+		}
+		spec.Min, spec.Max = minValue, maxValue
+		args = args[1:]
+	}
+
+	switch len(args) {
+	case 0:
+	case 1:
+		symbols := []rune(args[0])
+		if len(symbols) != 2 {
+			return Spec{}, false
+		}
+		spec.Filled, spec.Empty = string(symbols[0]), string(symbols[1])
+	case 2:
+		spec.Filled, spec.Empty = args[0], args[1]
+	default:
+		return Spec{}, false
+	}
+
+	if kind == KindPercent && spec.Filled != "" {
+		return Spec{}, false
+	}
+	return spec, spec.validRange()
+}
+
+func parseRange(value string) (int, int, bool) {
+	lower, upper, _ := strings.Cut(value, rangeSeparator)
+	minValue, err := strconv.Atoi(lower)
+	if err != nil {
+		return 0, 0, false
+	}
+	maxValue, err := strconv.Atoi(upper)
+	if err != nil {
+		return 0, 0, false
+	}
+	return minValue, maxValue, true
+}
+
+// validRange reports whether the range can be rendered: it must not be empty,
+// and symbol scales draw Max symbols so they cannot start below zero.
+func (s Spec) validRange() bool {
+	if s.Min >= s.Max {
+		return false
+	}
+	return s.Kind == KindPercent || s.Min >= 0
+}
+
+func parseChoices(value string, minChoices int) ([]string, bool) {
+	parts := strings.Split(value, "|")
+	choices := make([]string, 0, len(parts))
+	for _, part := range parts {
+		choice := strings.TrimSpace(part)
+		if choice == "" {
+			return nil, false
+		}
+		choices = append(choices, choice)
+	}
+	return choices, len(choices) >= minChoices
+}
+
+// merge fills the unset parts of s from other, so the first occurrence of a
+// named placeholder that declares something wins. Marking any occurrence
+// optional makes the placeholder optional.
+func (s *Spec) merge(other Spec) {
+	optional := s.Optional || other.Optional
+	if s.Kind == KindText && other.Kind != KindText {
+		defaultValue := s.Default
+		*s = other
+		if defaultValue != "" {
+			s.Default = defaultValue
+		}
+	}
+	if s.Default == "" {
+		s.Default = other.Default
+	}
+	s.Optional = optional
+}
+
+// apply overrides s with the non-empty settings of a field declaration.
+func (s *Spec) apply(field Field) error {
+	if field.Default != "" {
+		s.Default = field.Default
+	}
+	if len(field.Choices) > 0 {
+		// Choices make a single choice of anything but a list, whose items
+		// become a checklist of them.
+		if s.Kind.IsList() {
+			s.Kind = KindCheck
+		} else {
+			s.Kind = KindChoice
+		}
+		s.Choices = append([]string(nil), field.Choices...)
+	}
+	if field.Type != "" && !slices.Contains(declarableKinds, field.Type) {
+		names := make([]string, len(declarableKinds))
+		for i, kind := range declarableKinds {
+			names[i] = string(kind)
+		}
+		return fmt.Errorf("unknown type %q (available: %s)", field.Type, strings.Join(names, ", "))
+	}
+	if field.Type != "" && field.Type != s.Kind {
+		s.Kind = field.Type
+		if defaults, ok := scaleDefaults[s.Kind]; ok {
+			s.Min, s.Max, s.Filled, s.Empty = defaults.Min, defaults.Max, defaults.Filled, defaults.Empty
+		}
+	}
+	if field.Min != nil {
+		s.Min = *field.Min
+	}
+	if field.Max != nil {
+		s.Max = *field.Max
+	}
+	if symbols := []rune(field.Symbols); len(symbols) == 2 {
+		s.Filled, s.Empty = string(symbols[0]), string(symbols[1])
+	}
+	if (s.Kind == KindChoice || s.Kind == KindCheck) && len(s.Choices) == 0 {
+		return fmt.Errorf("type %s needs choices", s.Kind)
+	}
+	if s.Kind.IsScale() && !s.validRange() {
+		return fmt.Errorf("invalid range %d..%d for %s", s.Min, s.Max, s.Kind)
+	}
+	if field.Pattern != "" {
+		pattern, err := compilePattern(field.Pattern)
+		if err != nil {
+			return err
+		}
+		s.Pattern = pattern
+	}
+	if field.MinLength > 0 {
+		s.MinLength = field.MinLength
+	}
+	if field.MaxLength > 0 {
+		s.MaxLength = field.MaxLength
+	}
+	if field.Message != "" {
+		s.Message = field.Message
+	}
+	if field.Optional {
+		s.Optional = true
+	}
+	if field.Required {
+		s.Required = true
+	}
+	if s.Required && s.Optional {
+		return errors.New("required and optional cannot both be set")
+	}
+	return nil
+}
+
+// compilePattern anchors the expression so that it must match the whole answer.
+func compilePattern(expr string) (*regexp.Regexp, error) {
+	pattern, err := regexp.Compile(`^(?:` + expr + `)$`)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern %q: %w", expr, err)
+	}
+	return pattern, nil
+}
+
+// DefaultValue returns the default as a Value of the placeholder's kind.
+func (s Spec) DefaultValue() Value {
+	return s.ParseValue(s.Default)
+}
+
+// ParseValue converts a textual answer into a Value of the placeholder's kind.
+// List kinds take comma-separated items.
+func (s Spec) ParseValue(text string) Value {
+	if !s.Kind.IsList() {
+		return TextValue(text)
+	}
+	return ListValue(splitItems(text)...)
+}
+
+// splitItems splits a comma-separated list, dropping empty items.
+func splitItems(value string) []string {
+	items := make([]string, 0)
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// renderScale draws a validated scale answer. Star and gauge kinds draw Max
+// symbols, of which the first number are filled.
+func (s Spec) renderScale(text string) string {
+	number, err := s.scaleValue(text)
+	if err != nil {
+		return text
+	}
+
+	if s.Kind == KindPercent {
+		percent := math.Round(float64(number-s.Min) * 100 / float64(s.Max-s.Min))
+		return strconv.Itoa(int(percent)) + "%"
+	}
+	return strings.Repeat(s.Filled, number) + strings.Repeat(s.Empty, s.Max-number)
+}
@@ -1,8 +1,10 @@
 package template
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
@@ -12,31 +14,138 @@ import (
 
 // Document represents the YAML schema for templates.
 type Document struct {
-	Title       string `yaml:"title"`
-	Description string `yaml:"description"`
-	Template    string `yaml:"template"`
+	// Version is the schema version; see CurrentVersion. LoadFile upgrades
+	// older documents in memory and WriteFile stamps the current version.
+	Version     int              `yaml:"version,omitempty"`
+	Title       string           `yaml:"title"`
+	Description string           `yaml:"description"`
+	Template    string           `yaml:"template"`
+	Fields      map[string]Field `yaml:"fields,omitempty"`
+	Markers     Markers          `yaml:"markers,omitempty"`
+	// Optional makes every placeholder optional; see Field.Optional.
+	Optional bool `yaml:"optional,omitempty"`
+	// Extends names a base template whose "{$name}...{/}" blocks are
+	// replaced by Blocks. It is resolved, and cleared, by LoadFile.
+	Extends string            `yaml:"extends,omitempty"`
+	Blocks  map[string]string `yaml:"blocks,omitempty"`
+}
+
+// Field declares metadata for a named placeholder. Fields tagged
+// schema:"scalar" hold answers, which YAML may write as numbers or booleans.
+type Field struct {
+	Type    Kind     `yaml:"type,omitempty"`
+	Default string   `yaml:"default,omitempty" schema:"scalar"`
+	Choices []string `yaml:"choices,omitempty" schema:"scalar"`
+	Min     *int     `yaml:"min,omitempty"`
+	Max     *int     `yaml:"max,omitempty"`
+	// Symbols holds the filled and empty symbols of a scale, e.g. "★☆".
+	Symbols string `yaml:"symbols,omitempty"`
+	// Pattern is a regular expression the whole answer must match.
+	Pattern   string `yaml:"pattern,omitempty"`
+	MinLength int    `yaml:"minLength,omitempty"`
+	MaxLength int    `yaml:"maxLength,omitempty"`
+	// Message replaces the error shown when a constraint is violated.
+	Message string `yaml:"message,omitempty"`
+	// Label, Hint and Example override the prompt label and add help text.
+	Label   string `yaml:"label,omitempty"`
+	Hint    string `yaml:"hint,omitempty"`
+	Example string `yaml:"example,omitempty" schema:"scalar"`
+	// Optional marks the placeholder optional; see Spec.Optional.
+	Optional bool `yaml:"optional,omitempty"`
+	// Required rejects empty answers; see Spec.Required.
+	Required bool `yaml:"required,omitempty"`
+}
+
+// Markers are the symbols placed before checklist options.
+type Markers struct {
+	Checked   string `yaml:"checked,omitempty"`
+	Unchecked string `yaml:"unchecked,omitempty"`
+}
+
+// Default markers used when a document does not configure its own.
+const (
+	DefaultCheckedMarker   = "☑"
+	DefaultUncheckedMarker = "☐"
+)
+
+func (m Markers) withDefaults() Markers {
+	if m.Checked == "" {
+		m.Checked = DefaultCheckedMarker
+	}
+	if m.Unchecked == "" {
+		m.Unchecked = DefaultUncheckedMarker
+	}
+	return m
 }
 
 // ErrTemplateMissing indicates that no template body was provided.
 var ErrTemplateMissing = errors.New("template is not defined")
 
-// LoadFile reads the YAML document from disk.
+// LoadFile reads the YAML document from disk, expands the partials its
+// template includes with "{>name}" and resolves the template it extends. The
+// result behaves exactly as if the flattened template had been written out.
+// A file holding several templates is an error; see LoadBundle.
 func LoadFile(path string) (Document, error) {
-	data, err := os.ReadFile(path)
+	docs, err := LoadBundle(path)
 	if err != nil {
 		return Document{}, err
 	}
+	if len(docs) != 1 {
+		return Document{}, fmt.Errorf("%s: %w: it holds %d templates", path, ErrBundle, len(docs))
+	}
+	return docs[0], nil
+}
+
+// decodeFile reads the templates of a YAML file, upgraded to CurrentVersion,
+// without resolving includes. A file holds one template, a "templates" list,
+// or a stream of either separated by "---".
+func decodeFile(path string) ([]Document, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	docs := make([]Document, 0, 1)
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	for {
+		var file bundleFile
+		if err := decoder.Decode(&file); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to decode template YAML: %w", err)
+		}
 
-	var doc Document
-	if err := yaml.Unmarshal(data, &doc); err != nil {
-		return Document{}, fmt.Errorf("failed to decode template YAML: %w", err)
+		if len(file.Templates) == 0 {
+			docs = append(docs, file.Document)
+			continue
+		}
+		if file.Template != "" {
+			return nil, fmt.Errorf("%s: a document has either template or templates, not both", path)
+		}
+		for _, doc := range file.Templates {
+			if doc.Version == 0 {
+				doc.Version = file.Version
+			}
+			docs = append(docs, doc)
+		}
+	}
+	if len(docs) == 0 {
+		docs = append(docs, Document{})
 	}
 
-	return doc, nil
+	for i := range docs {
+		if _, err := docs[i].Upgrade(); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return docs, nil
 }
 
 // WriteFile writes the document to disk, creating parent directories when required.
 func WriteFile(path string, doc Document) error {
+	if doc.Version == 0 {
+		doc.Version = CurrentVersion
+	}
 	data, err := yaml.Marshal(doc)
 	if err != nil {
 		return fmt.Errorf("failed to marshal template YAML: %w", err)
@@ -52,10 +161,37 @@ func WriteFile(path string, doc Document) error {
 	return os.WriteFile(path, data, 0o644)
 }
 
-// Validate ensures the template body is present.
+// NewSession parses the template body and applies the field declarations.
+func (d Document) NewSession() (*Session, error) {
+	session, err := NewSession(d.Template)
+	if err != nil {
+		return nil, err
+	}
+	if err := session.ApplyFields(d.Fields); err != nil {
+		return nil, err
+	}
+	session.markers = d.Markers.withDefaults()
+	session.optional = d.Optional
+	return session, nil
+}
+
+// Validate ensures the template body is present and free of syntax errors,
+// which are reported as a *ParseError, and that the fields section matches
+// the placeholders; see Check for the problems it looks for.
 func (d Document) Validate() error {
 	if strings.TrimSpace(d.Template) == "" {
 		return ErrTemplateMissing
 	}
-	return nil
+	session, err := d.NewSession()
+	if err != nil {
+		return err
+	}
+
+	errs := make([]error, 0)
+	for _, problem := range d.check(session) {
+		if problem.Severity == SeverityError {
+			errs = append(errs, problem)
+		}
+	}
+	return errors.Join(errs...)
 }
@@ -0,0 +1,165 @@
+package template
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ValidationError reports an answer rejected by a placeholder's spec.
+type ValidationError struct {
+	Label string
+	Err   error
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Label, e.Err)
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// Validate reports whether value is an acceptable answer for the placeholder.
+// Rejections are returned as *ValidationError carrying the placeholder label.
+func (p Placeholder) Validate(value Value) error {
+	if err := p.Spec.Validate(value); err != nil {
+		return &ValidationError{Label: p.Label, Err: err}
+	}
+	return nil
+}
+
+// Validate reports whether value is an acceptable answer. Emptiness is left to
+// the caller, so an empty value is always accepted here.
+func (s Spec) Validate(value Value) error {
+	if err := s.validateKind(value); err != nil {
+		return err
+	}
+	// The pattern and length limits of a list apply to each of its items.
+	for _, item := range value.Items {
+		if err := s.validateConstraints(item); err != nil {
+			return err
+		}
+	}
+	if value.Items != nil || value.Text == "" {
+		return nil
+	}
+	return s.validateConstraints(value.Text)
+}
+
+// validateConstraints checks the pattern and length limits of a text answer.
+func (s Spec) validateConstraints(text string) error {
+	var err error
+	length := utf8.RuneCountInString(text)
+	switch {
+	case s.MinLength > 0 && length < s.MinLength:
+		err = fmt.Errorf("must be at least %d characters (got %d)", s.MinLength, length)
+	case s.MaxLength > 0 && length > s.MaxLength:
+		err = fmt.Errorf("must be at most %d characters (got %d)", s.MaxLength, length)
+	case s.Pattern != nil && !s.Pattern.MatchString(text):
+		err = fmt.Errorf("%q does not match the required pattern", text)
+	}
+
+	if err != nil && s.Message != "" {
+		return errors.New(s.Message)
+	}
+	return err
+}
+
+func (s Spec) validateKind(value Value) error {
+	if s.Kind == KindCheck {
+		for _, item := range value.Items {
+			if err := s.checkChoice(item); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	text := strings.TrimSpace(value.Text)
+	if text == "" {
+		return nil
+	}
+
+	switch s.Kind {
+	case KindChoice:
+		return s.checkChoice(value.Text)
+	case KindStars, KindGauge, KindPercent:
+		_, err := s.scaleValue(text)
+		return err
+	case KindInt:
+		if _, err := strconv.Atoi(text); err != nil {
+			return fmt.Errorf("%q is not an integer", text)
+		}
+	case KindNumber:
+		number, err := strconv.ParseFloat(text, 64)
+		if err != nil || math.IsNaN(number) || math.IsInf(number, 0) {
+			return fmt.Errorf("%q is not a number", text)
+		}
+	case KindDate:
+		if _, ok := parseDate(text); !ok {
+			return fmt.Errorf("%q is not a date (e.g. 2006-01-02, 2006/1/2, 1/2, 1月2日)", text)
+		}
+	case KindHandle:
+		if !handlePattern.MatchString(text) {
+			return fmt.Errorf("%q is not a handle (up to 15 letters, digits or _)", text)
+		}
+	case KindURL:
+		return checkURL(text)
+	}
+
+	return nil
+}
+
+func (s Spec) checkChoice(value string) error {
+	for _, choice := range s.Choices {
+		if value == choice {
+			return nil
+		}
+	}
+	return fmt.Errorf("%q is not one of %s", value, strings.Join(s.Choices, ", "))
+}
+
+// scaleValue parses an answer of a scale kind and checks it against the range.
+func (s Spec) scaleValue(text string) (int, error) {
+	number, err := strconv.Atoi(strings.TrimSpace(text))
+	if err != nil || number < s.Min || number > s.Max {
+		return 0, fmt.Errorf("%q is not an integer between %d and %d", text, s.Min, s.Max)
+	}
+	return number, nil
+}
+
+var handlePattern = regexp.MustCompile(`^@?[A-Za-z0-9_]{1,15}$`)
+
+// dateLayouts lists the accepted date formats, with and without a year.
+var dateLayouts = []string{
+	"2006-01-02",
+	"2006/1/2",
+	"2006年1月2日",
+	"1/2",
+	"1-2",
+	"1月2日",
+}
+
+func parseDate(text string) (time.Time, bool) {
+	for _, layout := range dateLayouts {
+		if date, err := time.Parse(layout, text); err == nil {
+			return date, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func checkURL(text string) error {
+	parsed, err := url.Parse(text)
+	if err != nil || parsed.Host == "" || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+		return errors.New("expected an http or https URL")
+	}
+	return nil
+}
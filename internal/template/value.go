@@ -0,0 +1,33 @@
+package template
+
+import "strings"
+
+// Value is the answer for one placeholder. Text holds free-text and choice
+// answers, while Items holds the selection of list kinds such as KindCheck.
+type Value struct {
+	Text  string
+	Items []string
+}
+
+// TextValue returns a Value holding a single text answer.
+func TextValue(text string) Value {
+	return Value{Text: text}
+}
+
+// ListValue returns a Value holding a list of items.
+func ListValue(items ...string) Value {
+	return Value{Items: items}
+}
+
+// IsEmpty reports whether the value carries no answer.
+func (v Value) IsEmpty() bool {
+	return strings.TrimSpace(v.Text) == "" && len(v.Items) == 0
+}
+
+// String returns the text of the value, joining list items with ", ".
+func (v Value) String() string {
+	if v.Items != nil {
+		return strings.Join(v.Items, ", ")
+	}
+	return v.Text
+}